@@ -17,8 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
@@ -27,6 +31,99 @@ import (
 type SimpleChaincode struct {
 }
 
+// DEFAULT_PAGE_SIZE - number of pairs returned by range queries when no limit is given
+const DEFAULT_PAGE_SIZE = 100
+
+// RESERVED_PREFIX - keys starting with it hold the chaincode's own records and can not be written by callers
+const RESERVED_PREFIX = "~"
+
+// HISTORY_PREFIX - prefix of the history index, the past entries of a key are stored under it in write order
+const HISTORY_PREFIX = RESERVED_PREFIX + "history\x00"
+
+// SCHEMA_PREFIX - prefix of the JSON schemas registered for key prefixes
+const SCHEMA_PREFIX = RESERVED_PREFIX + "schema\x00"
+
+// ADMIN - role attribute of the callers that can register schemas and manage namespaces
+const ADMIN = "admin"
+
+// Types of the values in the store
+const (
+	TYPE_STRING  = "string"
+	TYPE_INT     = "int"
+	TYPE_DECIMAL = "decimal"
+	TYPE_BOOL    = "bool"
+	TYPE_JSON    = "json"
+)
+
+// Entry - a value as stored in the state together with its version, which counts the writes of the key,
+// the identity that first wrote the key and the identities its owner allows to read or write it. A key with
+// an ExpiresAt is absent from the transaction with that timestamp on.
+type Entry struct {
+	Value     string   `json:"Value"`
+	Type      string   `json:"Type"`
+	Version   int      `json:"Version"`
+	Owner     string   `json:"Owner"`
+	Readers   []string `json:"Readers"`
+	Writers   []string `json:"Writers"`
+	ExpiresAt string   `json:"ExpiresAt"`
+}
+
+// HistoryRecord - an entry of a key as it was written, or as it was when the key was deleted
+type HistoryRecord struct {
+	Entry
+	TxID      string `json:"TxID"`
+	Timestamp string `json:"Timestamp"`
+	Writer    string `json:"Writer"`
+	Deleted   bool   `json:"Deleted"`
+}
+
+// BatchOp - one operation of write_batch, Op is "put" or "delete". When a Version is given the operation
+// only applies if the key is at that version, 0 for a key that does not exist.
+type BatchOp struct {
+	Op        string `json:"Op"`
+	Key       string `json:"Key"`
+	Value     string `json:"Value"`
+	Type      string `json:"Type"`
+	ExpiresAt string `json:"ExpiresAt"`
+	Version   *int   `json:"Version"`
+}
+
+// Schema - a JSON schema the values of the keys of a namespace starting with Prefix must validate against
+type Schema struct {
+	Namespace    string          `json:"Namespace"`
+	Prefix       string          `json:"Prefix"`
+	Schema       json.RawMessage `json:"Schema"`
+	RegisteredBy string          `json:"RegisteredBy"`
+}
+
+// KeyValue - a key and its value as returned by range queries
+type KeyValue struct {
+	Key       string `json:"Key"`
+	Value     string `json:"Value"`
+	Type      string `json:"Type"`
+	Version   int    `json:"Version"`
+	ExpiresAt string `json:"ExpiresAt"`
+}
+
+// KeyValuePage - one page of a range query, NextKey continues the query when more keys are left
+type KeyValuePage struct {
+	Pairs   []KeyValue `json:"Pairs"`
+	NextKey string     `json:"NextKey"`
+}
+
+// DeletedKeys - the keys deleted by delete_prefix or purge_expired, NextKey continues the deletion when more keys are left
+type DeletedKeys struct {
+	Keys    []string `json:"Keys"`
+	NextKey string   `json:"NextKey"`
+}
+
+// Tombstone - payload of the "tombstone" event sent when keys are deleted
+type Tombstone struct {
+	Namespace string   `json:"Namespace"`
+	Keys      []string `json:"Keys"`
+	TxID      string   `json:"TxID"`
+}
+
 func main() {
 	err := shim.Start(new(SimpleChaincode))
 	if err != nil {
@@ -40,12 +137,12 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 		return nil, errors.New("Incorrect number of arguments. Expecting 1")
 	}
 
-	err := stub.PutState("hello_world", []byte(args[0]))
+	// The deploy seeds the default namespace, which does not need a caller with access to it.
+	ns, err := t.get_namespace(stub, DEFAULT_NAMESPACE)
 	if err != nil {
 		return nil, err
 	}
-
-	return nil, nil
+	return nil, t.seed(stub, ns, args[0])
 }
 
 // Invoke isur entry point to invoke a chaincode function
@@ -54,9 +151,33 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function stri
 
 	// Handle different functions
 	if function == "init" {
-		return t.Init(stub, "init", args)
+		return t.reset(stub, args)
 	} else if function == "write" {
 		return t.write(stub, args)
+	} else if function == "cas_write" {
+		return t.cas_write(stub, args)
+	} else if function == "write_batch" {
+		return t.write_batch(stub, args)
+	} else if function == "increment" {
+		return t.adjust(stub, args, 1)
+	} else if function == "decrement" {
+		return t.adjust(stub, args, -1)
+	} else if function == "delete" {
+		return t.delete(stub, args)
+	} else if function == "delete_prefix" {
+		return t.delete_prefix(stub, args)
+	} else if function == "purge_expired" {
+		return t.purge_expired(stub, args)
+	} else if function == "set_acl" {
+		return t.set_acl(stub, args)
+	} else if function == "register_schema" {
+		return t.register_schema(stub, args)
+	} else if function == "remove_schema" {
+		return t.remove_schema(stub, args)
+	} else if function == "create_namespace" {
+		return t.create_namespace(stub, args)
+	} else if function == "update_namespace" {
+		return t.update_namespace(stub, args)
 	}
 	fmt.Println("invoke did not find func: " + function)
 
@@ -70,46 +191,1107 @@ func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function strin
 	// Handle different functions
 	if function == "read" { //read a variable
 		return t.read(stub, args)
+	} else if function == "read_range" {
+		return t.read_range(stub, args)
+	} else if function == "read_prefix" {
+		return t.read_prefix(stub, args)
+	} else if function == "read_history" {
+		return t.read_history(stub, args)
+	} else if function == "read_namespace" {
+		return t.read_namespace(stub, args)
 	}
 	fmt.Println("query did not find func: " + function)
 
 	return nil, errors.New("Received unknown function query: " + function)
 }
 
-// write - invoke function to write key/value pair
+// reset - invoke function to write hello_world again, by a caller that can write the default namespace
+func (t *SimpleChaincode) reset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	}
+
+	ns, _, err := t.get_scope(stub, []string{DEFAULT_NAMESPACE}, true)
+	if err != nil {
+		return nil, err
+	}
+	return nil, t.seed(stub, ns, args[0])
+}
+
+// seed - writes hello_world to the default namespace. A deploy without identity attributes writes it without
+// an owner, so anyone can read it.
+func (t *SimpleChaincode) seed(stub shim.ChaincodeStubInterface, ns *Namespace, value string) error {
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		caller = ""
+	}
+	entry, err := t.get_entry(stub, state_key(ns, "hello_world"))
+	if err != nil {
+		return err
+	}
+	if !can_write(entry, caller) {
+		return errors.New("Permission denied. hello_world can only be written by its owner")
+	}
+	err = t.check_value(stub, ns, "hello_world", TYPE_STRING, value)
+	if err != nil {
+		return err
+	}
+	return t.put_entry(stub, ns, "hello_world", value, TYPE_STRING, "", entry, caller)
+}
+
+// write - invoke function to write key/value pair, optionally with the type of the value and the time it expires
 func (t *SimpleChaincode) write(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var key, value string
-	var err error
+	var key, value, expiresAt string
 	fmt.Println("running write()")
 
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2. name of the key and value to set")
+	ns, args, err := t.get_scope(stub, args, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 || len(args) > 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace, name of the key, value to set, and optionally its type and expiry time")
+	}
+	if len(args) == 4 {
+		expiresAt, err = t.parse_expiry(stub, args[3])
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	key = args[0] //rename for funsies
 	value = args[1]
-	err = stub.PutState(key, []byte(value)) //write the variable into the chaincode state
+	entry, caller, err := t.get_writable_entry(stub, ns, key)
+	if err != nil {
+		return nil, err
+	}
+	valueType := value_type(args[2:], entry)
+	err = t.check_value(stub, ns, key, valueType, value)
+	if err != nil {
+		return nil, err
+	}
+	err = t.put_entry(stub, ns, key, value, valueType, expiresAt, entry, caller) //write the variable into the chaincode state
 	if err != nil {
 		return nil, err
 	}
 	return nil, nil
 }
 
-// read - query function to read key/value pair
+// cas_write - invoke function to write key/value pair only when the key is still at the expected version, 0 for a new key
+func (t *SimpleChaincode) cas_write(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running cas_write()")
+
+	ns, args, err := t.get_scope(stub, args, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 3 || len(args) > 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace, name of the key, value to set, expected version, and optionally the type of the value and its expiry time")
+	}
+	expected, err := strconv.Atoi(args[2])
+	if err != nil || expected < 0 {
+		return nil, errors.New("Invalid expected version " + args[2])
+	}
+	expiresAt := ""
+	if len(args) == 5 {
+		expiresAt, err = t.parse_expiry(stub, args[4])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entry, caller, err := t.get_writable_entry(stub, ns, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = check_version(args[0], entry, expected)
+	if err != nil {
+		return nil, err
+	}
+	valueType := value_type(args[3:], entry)
+	err = t.check_value(stub, ns, args[0], valueType, args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, t.put_entry(stub, ns, args[0], args[1], valueType, expiresAt, entry, caller)
+}
+
+// write_batch - invoke function to apply a JSON list of put and delete operations, either all of them or none
+func (t *SimpleChaincode) write_batch(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var ops []BatchOp
+	var deleted []string
+	fmt.Println("running write_batch()")
+
+	ns, args, err := t.get_scope(stub, args, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace and a JSON list of operations")
+	}
+	err = json.Unmarshal([]byte(args[0]), &ops)
+	if err != nil {
+		return nil, errors.New("Operations must be a JSON list of {\"Op\", \"Key\", \"Value\", \"Type\", \"ExpiresAt\", \"Version\"} objects")
+	}
+
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every operation is checked against the keys as left by the operations before it, and nothing is
+	// written until all of them pass. The quota of the namespace is checked as the operations are applied,
+	// a batch failing it fails the transaction which leaves the state as it was.
+	entries := make([]*Entry, len(ops))
+	pending := map[string]*Entry{}
+	for i, op := range ops {
+		err = check_key(op.Key)
+		if err != nil {
+			return nil, err
+		}
+		entry, found := pending[op.Key]
+		if !found {
+			entry, err = t.get_entry(stub, state_key(ns, op.Key))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !can_write(entry, caller) {
+			return nil, errors.New("Permission denied. " + caller + " can not write " + op.Key)
+		}
+		if op.Version != nil {
+			err = check_version(op.Key, entry, *op.Version)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		entries[i] = entry
+		switch op.Op {
+		case "put":
+			ops[i].Type = value_type([]string{op.Type}, entry)
+			err = t.check_value(stub, ns, op.Key, ops[i].Type, op.Value)
+			if err != nil {
+				return nil, err
+			}
+			ops[i].ExpiresAt, err = t.parse_expiry(stub, op.ExpiresAt)
+			if err != nil {
+				return nil, err
+			}
+			next := next_entry(op.Value, ops[i].Type, ops[i].ExpiresAt, entry, caller)
+			pending[op.Key] = &next
+		case "delete":
+			pending[op.Key] = nil
+		default:
+			return nil, errors.New("Unknown operation " + op.Op + " on " + op.Key + ", expecting put or delete")
+		}
+	}
+
+	for i, op := range ops {
+		if op.Op == "put" {
+			err = t.put_entry(stub, ns, op.Key, op.Value, op.Type, op.ExpiresAt, entries[i], caller)
+		} else if entries[i] != nil {
+			err = t.remove_entry(stub, ns, op.Key, *entries[i], caller)
+			deleted = append(deleted, op.Key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+	return nil, t.send_tombstone(stub, ns, deleted)
+}
+
+// adjust - invoke function behind increment and decrement, adds or subtracts a delta from an int key, optionally
+// failing when the new value would be below a minimum or above a maximum, and returns the new value. A key that
+// does not exist counts from 0, as does a key that has expired, which restarts without an expiry. Strings, like
+// the values written before values had types, count when they hold an int.
+func (t *SimpleChaincode) adjust(stub shim.ChaincodeStubInterface, args []string, sign int64) ([]byte, error) {
+	fmt.Println("running adjust()")
+
+	ns, args, err := t.get_scope(stub, args, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 || len(args) > 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace, name of the key, delta, and optionally a minimum and maximum")
+	}
+	delta, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || delta < 0 {
+		return nil, errors.New("Invalid delta " + args[1] + ", expecting a non negative int")
+	}
+	bounds := make([]*int64, 2)
+	for i, bound := range args[2:] {
+		if bound == "" {
+			continue
+		}
+		limit, err := strconv.ParseInt(bound, 10, 64)
+		if err != nil {
+			return nil, errors.New("Invalid bound " + bound)
+		}
+		bounds[i] = &limit
+	}
+
+	entry, caller, err := t.get_writable_entry(stub, ns, args[0])
+	if err != nil {
+		return nil, err
+	}
+	current := int64(0)
+	if entry != nil {
+		if entry.Type != TYPE_INT && entry.Type != TYPE_STRING {
+			return nil, errors.New("Value of " + args[0] + " is a " + entry.Type + ", expecting an int")
+		}
+		_, err = typed_value(TYPE_INT, entry.Value)
+		if err != nil {
+			return nil, errors.New("Value of " + args[0] + " is not an int")
+		}
+		current, _ = strconv.ParseInt(entry.Value, 10, 64)
+	}
+
+	next := current + sign*delta
+	if (sign > 0 && next < current) || (sign < 0 && next > current) {
+		return nil, errors.New("Value of " + args[0] + " would overflow")
+	}
+	if bounds[0] != nil && next < *bounds[0] {
+		return nil, errors.New("Value of " + args[0] + " would be " + strconv.FormatInt(next, 10) + ", below the minimum " + args[2])
+	}
+	if bounds[1] != nil && next > *bounds[1] {
+		return nil, errors.New("Value of " + args[0] + " would be " + strconv.FormatInt(next, 10) + ", above the maximum " + args[3])
+	}
+
+	value := strconv.FormatInt(next, 10)
+	err = t.check_value(stub, ns, args[0], TYPE_INT, value)
+	if err != nil {
+		return nil, err
+	}
+	// A counter keeps its expiry, so a quota can be counted over a period. The next period starts when the
+	// expired counter is written with a new expiry.
+	expiresAt := ""
+	if entry != nil {
+		expiresAt = entry.ExpiresAt
+	}
+	err = t.put_entry(stub, ns, args[0], value, TYPE_INT, expiresAt, entry, caller)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+// set_acl - invoke function for the owner of a key to set the JSON lists of identities that can read and write it
+func (t *SimpleChaincode) set_acl(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var readers, writers []string
+	fmt.Println("running set_acl()")
+
+	ns, args, err := t.get_scope(stub, args, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4. namespace, name of the key, JSON list of readers and JSON list of writers")
+	}
+	err = json.Unmarshal([]byte(args[1]), &readers)
+	if err != nil {
+		return nil, errors.New("Readers must be a JSON list of identities")
+	}
+	err = json.Unmarshal([]byte(args[2]), &writers)
+	if err != nil {
+		return nil, errors.New("Writers must be a JSON list of identities")
+	}
+
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := t.get_entry(stub, state_key(ns, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, errors.New("Key " + args[0] + " does not exist")
+	}
+	if entry.Owner != caller {
+		return nil, errors.New("Permission denied. Only the owner can set the ACL of " + args[0])
+	}
+
+	entry.Readers = readers
+	entry.Writers = writers
+	return nil, t.store_entry(stub, state_key(ns, args[0]), *entry)
+}
+
+// register_schema - invoke function for an admin to set the JSON schema the values of keys starting with a prefix must validate against
+func (t *SimpleChaincode) register_schema(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running register_schema()")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3. namespace, key prefix and JSON schema")
+	}
+	ns, err := t.get_namespace(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	args = args[1:]
+	err = check_key(args[0])
+	if err != nil {
+		return nil, err
+	}
+	schema, err := decode_json(args[1])
+	if err != nil {
+		return nil, errors.New("Schema of " + args[0] + " is not valid JSON")
+	}
+	err = check_schema(schema, "")
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := t.get_admin(stub, "register schemas")
+	if err != nil {
+		return nil, err
+	}
+	schemaAsbytes, err := json.Marshal(Schema{Namespace: ns.Name, Prefix: args[0], Schema: json.RawMessage(args[1]), RegisteredBy: caller})
+	if err != nil {
+		return nil, err
+	}
+	return nil, stub.PutState(SCHEMA_PREFIX+state_key(ns, args[0]), schemaAsbytes)
+}
+
+// remove_schema - invoke function for an admin to remove the JSON schema of a key prefix
+func (t *SimpleChaincode) remove_schema(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running remove_schema()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace and key prefix")
+	}
+	ns, err := t.get_namespace(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.get_admin(stub, "remove schemas")
+	if err != nil {
+		return nil, err
+	}
+	return nil, stub.DelState(SCHEMA_PREFIX + state_key(ns, args[1]))
+}
+
+// read - query function to read the value and version of a key
 func (t *SimpleChaincode) read(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var key, jsonResp string
-	var err error
+	ns, args, err := t.get_scope(stub, args, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace and name of the key to query")
+	}
+	err = check_key(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := t.get_entry(stub, state_key(ns, args[0]))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	if !can_read(entry, caller) {
+		return nil, errors.New("Permission denied. " + caller + " can not read " + args[0])
+	}
+	return json.Marshal(entry)
+}
 
+// read_history - query function to read every value a key has had, oldest first
+func (t *SimpleChaincode) read_history(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var history []HistoryRecord
+	fmt.Println("running read_history()")
+
+	ns, args, err := t.get_scope(stub, args, false)
+	if err != nil {
+		return nil, err
+	}
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the key to query")
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace and name of the key to query")
+	}
+	err = check_key(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := HISTORY_PREFIX + state_key(ns, args[0]) + "\x00"
+	keysIter, err := stub.RangeQueryState(prefix, prefix+"\xff")
+	if err != nil {
+		return nil, errors.New("Failed to get history of " + args[0])
+	}
+	defer keysIter.Close()
+
+	history = []HistoryRecord{}
+	for keysIter.HasNext() {
+		_, value, err := keysIter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var record HistoryRecord
+		err = json.Unmarshal(value, &record)
+		if err != nil {
+			return nil, errors.New("Failed to decode history of " + args[0])
+		}
+		history = append(history, record)
+	}
+
+	// The history is readable by whoever can read the latest entry, the entry as deleted for a deleted key.
+	if len(history) > 0 && !can_read(&history[len(history)-1].Entry, caller) {
+		return nil, errors.New("Permission denied. " + caller + " can not read the history of " + args[0])
+	}
+	return json.Marshal(history)
+}
+
+// read_range - query function to read the ordered key/value pairs from a start key to an end key inclusive
+func (t *SimpleChaincode) read_range(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running read_range()")
+
+	ns, args, err := t.get_scope(stub, args, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 || len(args) > 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace, start key, end key, and optionally a limit and continuation key")
+	}
+	return t.read_page(stub, ns, args[0], args[1], args[2:])
+}
+
+// read_prefix - query function to read the ordered key/value pairs of the keys starting with a prefix
+func (t *SimpleChaincode) read_prefix(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running read_prefix()")
+
+	ns, args, err := t.get_scope(stub, args, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 || len(args) > 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace, key prefix, and optionally a limit and continuation key")
+	}
+	return t.read_page(stub, ns, args[0], args[0]+"\xff", args[1:])
+}
+
+// read_page - reads up to limit pairs of a namespace between two keys, starting from the continuation key when one is given
+func (t *SimpleChaincode) read_page(stub shim.ChaincodeStubInterface, ns *Namespace, startKey string, endKey string, paging []string) ([]byte, error) {
+	var page KeyValuePage
+
+	limit, startKey, err := parse_paging(paging, startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	keysIter, err := stub.RangeQueryState(state_key(ns, startKey), state_key(ns, endKey))
+	if err != nil {
+		return nil, errors.New("Failed to get keys from " + startKey + " to " + endKey)
+	}
+	defer keysIter.Close()
+
+	page.Pairs = []KeyValue{}
+	for keysIter.HasNext() {
+		stateKey, value, err := keysIter.Next()
+		if err != nil {
+			return nil, err
+		}
+		key, found := namespace_key(ns, stateKey)
+		if !found {
+			continue
+		}
+		entry := decode_entry(value)
+		if is_expired(entry, now) || !can_read(entry, caller) {
+			continue
+		}
+		if len(page.Pairs) == limit {
+			page.NextKey = key
+			break
+		}
+		page.Pairs = append(page.Pairs, KeyValue{Key: key, Value: entry.Value, Type: entry.Type, Version: entry.Version, ExpiresAt: entry.ExpiresAt})
+	}
+
+	return json.Marshal(page)
+}
+
+// parse_paging - the limit and the start key of a page of the keys between two keys, from the optional limit
+// and continuation key arguments
+func parse_paging(paging []string, startKey string, endKey string) (int, string, error) {
+	var err error
+
+	limit := DEFAULT_PAGE_SIZE
+	if len(paging) > 0 && paging[0] != "" {
+		limit, err = strconv.Atoi(paging[0])
+		if err != nil || limit <= 0 {
+			return 0, "", errors.New("Invalid limit " + paging[0])
+		}
+	}
+	if len(paging) > 1 && paging[1] != "" {
+		if paging[1] < startKey || paging[1] > endKey {
+			return 0, "", errors.New("Continuation key " + paging[1] + " is outside the range")
+		}
+		startKey = paging[1]
+	}
+	return limit, startKey, nil
+}
+
+// delete - invoke function to remove a key, optionally only when its current value equals the expected value
+func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var key string
+	fmt.Println("running delete()")
+
+	ns, args, err := t.get_scope(stub, args, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace, name of the key to delete and optionally its expected value")
 	}
 
 	key = args[0]
-	valAsbytes, err := stub.GetState(key)
+	entry, caller, err := t.get_writable_entry(stub, ns, key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		if len(args) == 2 {
+			return nil, errors.New("Key " + key + " does not exist")
+		}
+		return nil, nil
+	}
+	if len(args) == 2 && entry.Value != args[1] {
+		return nil, errors.New("Current value of " + key + " does not match the expected value")
+	}
+
+	err = t.remove_entry(stub, ns, key, *entry, caller)
+	if err != nil {
+		return nil, err
+	}
+	return nil, t.send_tombstone(stub, ns, []string{key})
+}
+
+// delete_prefix - invoke function to remove up to limit keys starting with a prefix, starting from the continuation
+// key when one is given
+func (t *SimpleChaincode) delete_prefix(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var result DeletedKeys
+	var entries []*Entry
+	fmt.Println("running delete_prefix()")
+
+	ns, args, err := t.get_scope(stub, args, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 1 || len(args) > 3 || args[0] == "" {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace, a non empty key prefix, and optionally a limit and continuation key")
+	}
+	err = check_key(args[0])
+	if err != nil {
+		return nil, err
+	}
+	limit, startKey, err := parse_paging(args[1:], args[0], args[0]+"\xff")
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	keysIter, err := stub.RangeQueryState(state_key(ns, startKey), state_key(ns, args[0]+"\xff"))
+	if err != nil {
+		return nil, errors.New("Failed to get keys starting with " + args[0])
+	}
+	result.Keys = []string{}
+	for keysIter.HasNext() {
+		stateKey, value, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return nil, err
+		}
+		key, found := namespace_key(ns, stateKey)
+		if !found {
+			continue
+		}
+		if len(result.Keys) == limit {
+			result.NextKey = key
+			break
+		}
+		entry := decode_entry(value)
+		if !can_write(entry, caller) {
+			keysIter.Close()
+			return nil, errors.New("Permission denied. " + caller + " can not delete " + key)
+		}
+		result.Keys = append(result.Keys, key)
+		entries = append(entries, entry)
+	}
+	keysIter.Close()
+
+	for i, key := range result.Keys {
+		err = t.remove_entry(stub, ns, key, *entries[i], caller)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(result.Keys) > 0 {
+		err = t.send_tombstone(stub, ns, result.Keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(result)
+}
+
+// purge_expired - invoke function to delete the expired keys among up to limit keys of a namespace, starting from the
+// continuation key when one is given. The limit bounds the keys scanned, which in the default namespace include the
+// chaincode's own records, so a purge finding few expired keys still ends within the transaction.
+func (t *SimpleChaincode) purge_expired(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var result DeletedKeys
+	var entries []*Entry
+	fmt.Println("running purge_expired()")
+
+	ns, args, err := t.get_scope(stub, args, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace, and optionally a limit and continuation key")
+	}
+	limit, startKey, err := parse_paging(args, "", "\xff")
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	keysIter, err := stub.RangeQueryState(state_key(ns, startKey), state_key(ns, "\xff"))
+	if err != nil {
+		return nil, errors.New("Failed to get keys from " + startKey)
+	}
+	result.Keys = []string{}
+	scanned := 0
+	for keysIter.HasNext() {
+		stateKey, value, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return nil, err
+		}
+		key, found := namespace_key(ns, stateKey)
+		if scanned == limit {
+			result.NextKey = key
+			break
+		}
+		scanned++
+		if !found {
+			continue
+		}
+		entry := decode_entry(value)
+		if !is_expired(entry, now) {
+			continue
+		}
+		result.Keys = append(result.Keys, key)
+		entries = append(entries, entry)
+	}
+	keysIter.Close()
+
+	// Expired keys are absent for everyone, so anyone can purge them regardless of their ACL.
+	for i, key := range result.Keys {
+		err = t.remove_entry(stub, ns, key, *entries[i], caller)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(result.Keys) > 0 {
+		err = t.send_tombstone(stub, ns, result.Keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(result)
+}
+
+// send_tombstone - tells watchers which keys of a namespace were removed by this transaction
+func (t *SimpleChaincode) send_tombstone(stub shim.ChaincodeStubInterface, ns *Namespace, keys []string) error {
+	payload, err := json.Marshal(Tombstone{Namespace: ns.Name, Keys: keys, TxID: stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent("tombstone", payload)
+}
+
+// check_version - fails with a conflict unless the key is at the expected version
+func check_version(key string, entry *Entry, expected int) error {
+	current := 0
+	if entry != nil {
+		current = entry.Version
+	}
+	if current != expected {
+		return errors.New("Conflict: " + key + " is at version " + strconv.Itoa(current) + ", expected version " + strconv.Itoa(expected))
+	}
+	return nil
+}
+
+// get_entry - reads the entry stored at a state key, nil when the key does not exist or has expired
+func (t *SimpleChaincode) get_entry(stub shim.ChaincodeStubInterface, stateKey string) (*Entry, error) {
+	valAsbytes, err := stub.GetState(stateKey)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + key + "\"}"
+		jsonResp := "{\"Error\":\"Failed to get state for " + stateKey + "\"}"
 		return nil, errors.New(jsonResp)
 	}
+	if valAsbytes == nil {
+		return nil, nil
+	}
+
+	entry := decode_entry(valAsbytes)
+	if entry.ExpiresAt != "" {
+		now, err := t.get_tx_time(stub)
+		if err != nil {
+			return nil, err
+		}
+		if is_expired(entry, now) {
+			return nil, nil
+		}
+	}
+	return entry, nil
+}
+
+// is_expired - whether an entry has expired at a time
+func is_expired(entry *Entry, now time.Time) bool {
+	if entry.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339Nano, entry.ExpiresAt)
+	return err == nil && !now.Before(expiresAt)
+}
+
+// parse_expiry - parses an RFC 3339 expiry time, which must be after the transaction, none when it is empty
+func (t *SimpleChaincode) parse_expiry(stub shim.ChaincodeStubInterface, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return "", errors.New("Invalid expiry time " + value + ", expecting an RFC 3339 time")
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return "", err
+	}
+	if !expiresAt.After(now) {
+		return "", errors.New("Expiry time " + value + " is not after the transaction time")
+	}
+	return expiresAt.UTC().Format(time.RFC3339Nano), nil
+}
+
+// decode_entry - values written before versioning are stored raw and read as version 0, values written
+// before values had types are strings
+func decode_entry(valAsbytes []byte) *Entry {
+	var entry Entry
+
+	err := json.Unmarshal(valAsbytes, &entry)
+	if err != nil || entry.Version < 1 {
+		return &Entry{Value: string(valAsbytes), Type: TYPE_STRING}
+	}
+	if entry.Type == "" {
+		entry.Type = TYPE_STRING
+	}
+	return &entry
+}
+
+// next_entry - the entry holding a new value of a key as the version after its current entry, keeping its owner
+// and ACL. The writer becomes the owner of a new key, or of a key written before keys had owners.
+func next_entry(value string, valueType string, expiresAt string, current *Entry, writer string) Entry {
+	entry := Entry{Value: value, Type: valueType, Version: 1, Owner: writer, ExpiresAt: expiresAt}
+	if current != nil {
+		entry.Version = current.Version + 1
+		if current.Owner != "" {
+			entry.Owner = current.Owner
+			entry.Readers = current.Readers
+			entry.Writers = current.Writers
+		}
+	}
+	return entry
+}
+
+// put_entry - stores a new value of a key of a namespace, counts it against the namespace's quota and records
+// it in the key's history
+func (t *SimpleChaincode) put_entry(stub shim.ChaincodeStubInterface, ns *Namespace, key string, value string, valueType string, expiresAt string, current *Entry, writer string) error {
+	stateKey := state_key(ns, key)
+	entry := next_entry(value, valueType, expiresAt, current, writer)
+
+	// The usage counts the stored entry even when it has expired, until it is overwritten or purged.
+	storedAsbytes, err := stub.GetState(stateKey)
+	if err != nil {
+		return errors.New("Failed to get state for " + key)
+	}
+	keys, bytes := 1, entry_size(key, &entry)
+	if storedAsbytes != nil {
+		keys, bytes = 0, bytes-entry_size(key, decode_entry(storedAsbytes))
+	}
+	err = t.update_usage(stub, ns, keys, bytes)
+	if err != nil {
+		return err
+	}
+
+	err = t.store_entry(stub, stateKey, entry)
+	if err != nil {
+		return err
+	}
+	return t.add_history(stub, stateKey, entry, writer, false)
+}
+
+// remove_entry - deletes a key of a namespace, recording the entry it had in its history
+func (t *SimpleChaincode) remove_entry(stub shim.ChaincodeStubInterface, ns *Namespace, key string, entry Entry, writer string) error {
+	stateKey := state_key(ns, key)
+	err := stub.DelState(stateKey)
+	if err != nil {
+		return err
+	}
+	err = t.update_usage(stub, ns, -1, -entry_size(key, &entry))
+	if err != nil {
+		return err
+	}
+	return t.add_history(stub, stateKey, entry, writer, true)
+}
+
+// add_history - appends an entry to the history index of a state key. The index keeps the number of records
+// of the key under HISTORY_PREFIX + key, and each record under the key followed by its zero padded sequence.
+func (t *SimpleChaincode) add_history(stub shim.ChaincodeStubInterface, key string, entry Entry, writer string, deleted bool) error {
+	countKey := HISTORY_PREFIX + key
+	countAsbytes, err := stub.GetState(countKey)
+	if err != nil {
+		return errors.New("Failed to get history of " + key)
+	}
+	count := 0
+	if countAsbytes != nil {
+		count, err = strconv.Atoi(string(countAsbytes))
+		if err != nil {
+			return errors.New("Failed to decode history of " + key)
+		}
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+	record := HistoryRecord{Entry: entry, TxID: stub.GetTxID(), Timestamp: now.Format(time.RFC3339Nano), Writer: writer, Deleted: deleted}
+	recordAsbytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(countKey+"\x00"+fmt.Sprintf("%010d", count), recordAsbytes)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(countKey, []byte(strconv.Itoa(count+1)))
+}
+
+// get_tx_time - the timestamp of the transaction
+func (t *SimpleChaincode) get_tx_time(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, errors.New("Failed to get transaction timestamp")
+	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
+}
+
+// check_key - keys can not contain the null character used by the history index or start with RESERVED_PREFIX
+func check_key(key string) error {
+	if strings.HasPrefix(key, RESERVED_PREFIX) || strings.Contains(key, "\x00") {
+		return errors.New("Invalid key " + key + ", keys can not start with " + RESERVED_PREFIX + " or contain null characters")
+	}
+	return nil
+}
+
+// store_entry - writes an entry to the state
+func (t *SimpleChaincode) store_entry(stub shim.ChaincodeStubInterface, key string, entry Entry) error {
+	entryAsbytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, entryAsbytes)
+}
+
+// get_writable_entry - reads the entry of a key of a namespace after checking the caller can write it
+func (t *SimpleChaincode) get_writable_entry(stub shim.ChaincodeStubInterface, ns *Namespace, key string) (*Entry, string, error) {
+	err := check_key(key)
+	if err != nil {
+		return nil, "", err
+	}
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		return nil, "", err
+	}
+	entry, err := t.get_entry(stub, state_key(ns, key))
+	if err != nil {
+		return nil, "", err
+	}
+	if !can_write(entry, caller) {
+		return nil, "", errors.New("Permission denied. " + caller + " can not write " + key)
+	}
+	return entry, caller, nil
+}
+
+// value_type - the type given for a value, or when none is given the type of the current value of the key
+func value_type(args []string, current *Entry) string {
+	if len(args) > 0 && args[0] != "" {
+		return args[0]
+	}
+	if current != nil {
+		return current.Type
+	}
+	return TYPE_STRING
+}
+
+// check_value - fails unless the value is of its type and validates against the schema of the longest
+// prefix of the key registered in its namespace
+func (t *SimpleChaincode) check_value(stub shim.ChaincodeStubInterface, ns *Namespace, key string, valueType string, value string) error {
+	instance, err := typed_value(valueType, value)
+	if err != nil {
+		return err
+	}
+
+	keysIter, err := stub.RangeQueryState(SCHEMA_PREFIX, SCHEMA_PREFIX+"\xff")
+	if err != nil {
+		return errors.New("Failed to get schemas")
+	}
+	var schema *Schema
+	for keysIter.HasNext() {
+		_, schemaAsbytes, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return err
+		}
+		var registered Schema
+		err = json.Unmarshal(schemaAsbytes, &registered)
+		if err != nil {
+			keysIter.Close()
+			return errors.New("Failed to decode schemas")
+		}
+		// Schemas registered before namespaces belong to the default namespace.
+		if registered.Namespace == "" {
+			registered.Namespace = DEFAULT_NAMESPACE
+		}
+		if registered.Namespace != ns.Name || !strings.HasPrefix(key, registered.Prefix) {
+			continue
+		}
+		if schema == nil || len(registered.Prefix) > len(schema.Prefix) {
+			schema = &registered
+		}
+	}
+	keysIter.Close()
+	if schema == nil {
+		return nil
+	}
+
+	definition, err := decode_json(string(schema.Schema))
+	if err != nil {
+		return errors.New("Failed to decode schema of " + schema.Prefix)
+	}
+	err = validate_schema(definition, instance, "")
+	if err != nil {
+		return errors.New("Value of " + key + " does not match the schema of " + schema.Prefix + ": " + err.Error())
+	}
+	return nil
+}
 
-	return valAsbytes, nil
+// typed_value - parses a value of a type into the JSON value it is validated as
+func typed_value(valueType string, value string) (interface{}, error) {
+	switch valueType {
+	case TYPE_STRING:
+		return value, nil
+	case TYPE_INT:
+		_, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || !int_pattern.MatchString(value) {
+			return nil, errors.New("Value " + value + " is not an int")
+		}
+		return json.Number(value), nil
+	case TYPE_DECIMAL:
+		if !decimal_pattern.MatchString(value) {
+			return nil, errors.New("Value " + value + " is not a decimal")
+		}
+		return json.Number(value), nil
+	case TYPE_BOOL:
+		if value != "true" && value != "false" {
+			return nil, errors.New("Value " + value + " is not a bool, expecting true or false")
+		}
+		return value == "true", nil
+	case TYPE_JSON:
+		instance, err := decode_json(value)
+		if err != nil {
+			return nil, errors.New("Value " + value + " is not valid JSON")
+		}
+		return instance, nil
+	}
+	return nil, errors.New("Unknown type " + valueType + ", expecting string, int, decimal, bool or json")
+}
+
+// get_admin - the identity of the caller, who must have the admin role
+func (t *SimpleChaincode) get_admin(stub shim.ChaincodeStubInterface, action string) (string, error) {
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		return "", err
+	}
+	if !t.is_admin(stub) {
+		return "", errors.New("Permission denied. Only an admin can " + action)
+	}
+	return caller, nil
+}
+
+// is_admin - whether the caller has the admin role
+func (t *SimpleChaincode) is_admin(stub shim.ChaincodeStubInterface) bool {
+	role, err := stub.ReadCertAttribute("role")
+	return err == nil && string(role) == ADMIN
+}
+
+// get_caller - the identity of the caller, taken from the username attribute of its certificate
+func (t *SimpleChaincode) get_caller(stub shim.ChaincodeStubInterface) (string, error) {
+	username, err := stub.ReadCertAttribute("username")
+	if err != nil || len(username) == 0 {
+		return "", errors.New("Couldn't get attribute 'username'")
+	}
+	return string(username), nil
+}
+
+// can_read - the owner and the readers and writers of a key can read it, anyone can read a key without an owner
+func can_read(entry *Entry, caller string) bool {
+	return entry == nil || entry.Owner == "" || entry.Owner == caller || contains(entry.Readers, caller) || contains(entry.Writers, caller)
+}
+
+// can_write - the owner and the writers of a key can write it, anyone can write a new key or a key without an owner
+func can_write(entry *Entry, caller string) bool {
+	return entry == nil || entry.Owner == "" || entry.Owner == caller || contains(entry.Writers, caller)
+}
+
+// contains - whether an identity is in a list
+func contains(identities []string, identity string) bool {
+	for _, id := range identities {
+		if id == identity {
+			return true
+		}
+	}
+	return false
 }
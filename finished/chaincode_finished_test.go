@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDeletePrefixPages(t *testing.T) {
+	stub := new_test_stub(t).caller("alice", "user")
+	for _, key := range []string{"cfg/a", "cfg/b", "cfg/c", "other"} {
+		test_invoke(t, stub, "write", DEFAULT_NAMESPACE, key, "1")
+	}
+
+	tests := []struct {
+		args []string
+		want DeletedKeys
+	}{
+		{args: []string{DEFAULT_NAMESPACE, "cfg/", "2"}, want: DeletedKeys{Keys: []string{"cfg/a", "cfg/b"}, NextKey: "cfg/c"}},
+		{args: []string{DEFAULT_NAMESPACE, "cfg/", "2", "cfg/c"}, want: DeletedKeys{Keys: []string{"cfg/c"}}},
+		{args: []string{DEFAULT_NAMESPACE, "cfg/"}, want: DeletedKeys{Keys: []string{}}},
+	}
+	for _, test := range tests {
+		var got DeletedKeys
+		json.Unmarshal(test_invoke(t, stub, "delete_prefix", test.args...), &got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("delete_prefix %q = %+v, want %+v", test.args, got, test.want)
+		}
+	}
+
+	entry, err := new(SimpleChaincode).get_entry(stub, "other")
+	if err != nil || entry == nil {
+		t.Errorf("other = %v, %v, want it kept", entry, err)
+	}
+	_, err = new(SimpleChaincode).Invoke(stub, "delete_prefix", []string{DEFAULT_NAMESPACE, "cfg/", "2", "zzz"})
+	if err == nil {
+		t.Error("delete_prefix with a continuation key outside the prefix succeeded")
+	}
+}
+
+func TestKeyACL(t *testing.T) {
+	owned := &Entry{Owner: "alice", Readers: []string{"bob"}, Writers: []string{"carol"}}
+	legacy := &Entry{Value: "v", Type: TYPE_STRING}
+
+	tests := []struct {
+		name      string
+		entry     *Entry
+		caller    string
+		readable  bool
+		writeable bool
+	}{
+		{name: "new key", entry: nil, caller: "bob", readable: true, writeable: true},
+		{name: "key without owner", entry: legacy, caller: "bob", readable: true, writeable: true},
+		{name: "owner", entry: owned, caller: "alice", readable: true, writeable: true},
+		{name: "reader", entry: owned, caller: "bob", readable: true, writeable: false},
+		{name: "writer", entry: owned, caller: "carol", readable: true, writeable: true},
+		{name: "stranger", entry: owned, caller: "dave", readable: false, writeable: false},
+		{name: "no identity", entry: owned, caller: "", readable: false, writeable: false},
+	}
+	for _, test := range tests {
+		if got := can_read(test.entry, test.caller); got != test.readable {
+			t.Errorf("%s: can_read = %v, want %v", test.name, got, test.readable)
+		}
+		if got := can_write(test.entry, test.caller); got != test.writeable {
+			t.Errorf("%s: can_write = %v, want %v", test.name, got, test.writeable)
+		}
+	}
+}
+
+func TestInitKeepsOwner(t *testing.T) {
+	stub := new_test_stub(t)
+	entry, err := new(SimpleChaincode).get_entry(stub, "hello_world")
+	if err != nil || entry == nil || entry.Owner != "" {
+		t.Fatalf("hello_world after a deploy without identity = %+v, %v, want it without owner", entry, err)
+	}
+
+	// alice becomes the owner of hello_world by writing it
+	stub.caller("alice", "user")
+	test_invoke(t, stub, "write", DEFAULT_NAMESPACE, "hello_world", "mine")
+
+	for _, caller := range []string{"bob", ""} {
+		stub.attributes = map[string]string{}
+		if caller != "" {
+			stub.caller(caller, "user")
+		}
+		_, err = new(SimpleChaincode).Init(stub, "init", []string{"taken"})
+		if err == nil {
+			t.Errorf("Init by %q overwrote hello_world owned by alice", caller)
+		}
+	}
+}
+
+func TestAdjustStoredValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored string
+		want   string
+	}{
+		{name: "new key", stored: "", want: "3"},
+		{name: "int", stored: `{"Value":"4","Type":"int","Version":1}`, want: "7"},
+		{name: "untyped", stored: `{"Value":"5","Version":1}`, want: "8"},
+		{name: "written before versions", stored: "6", want: "9"},
+		{name: "string", stored: `{"Value":"-7","Type":"string","Version":2}`, want: "-4"},
+		{name: "string that is not an int", stored: "07", want: ""},
+		{name: "json", stored: `{"Value":"8","Type":"json","Version":1}`, want: ""},
+	}
+	for _, test := range tests {
+		stub := new_test_stub(t).caller("alice", "user")
+		if test.stored != "" {
+			stub.MockStub.State["counter"] = []byte(test.stored)
+		}
+
+		value, err := new(SimpleChaincode).Invoke(stub, "increment", []string{DEFAULT_NAMESPACE, "counter", "3"})
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s: increment of %s succeeded", test.name, test.stored)
+			}
+			continue
+		}
+		if err != nil || string(value) != test.want {
+			t.Errorf("%s: increment = %s, %v, want %s", test.name, value, err, test.want)
+		}
+	}
+}
+
+func TestAdjustExpiredCounter(t *testing.T) {
+	stub := new_test_stub(t).caller("alice", "user")
+	test_invoke(t, stub, "write", DEFAULT_NAMESPACE, "quota", "5", TYPE_INT, "2023-11-15T00:00:00Z")
+	test_invoke(t, stub, "increment", DEFAULT_NAMESPACE, "quota", "1")
+	entry, _ := new(SimpleChaincode).get_entry(stub, "quota")
+	if entry.Value != "6" || entry.ExpiresAt != "2023-11-15T00:00:00Z" {
+		t.Errorf("counter before it expires = %+v, want 6 keeping its expiry", entry)
+	}
+
+	stub.seconds += 7 * 24 * 60 * 60
+	value := test_invoke(t, stub, "increment", DEFAULT_NAMESPACE, "quota", "1")
+	entry, _ = new(SimpleChaincode).get_entry(stub, "quota")
+	if string(value) != "1" || entry.ExpiresAt != "" {
+		t.Errorf("counter after it expired = %+v, want 1 without expiry", entry)
+	}
+}
+
+func TestPurgeExpiredPages(t *testing.T) {
+	stub := new_test_stub(t).caller("root", ADMIN)
+	test_invoke(t, stub, "create_namespace", "tmp", `["*"]`, `["*"]`)
+	test_invoke(t, stub, "write", "tmp", "a", "1", TYPE_STRING, "2023-11-15T00:00:00Z")
+	test_invoke(t, stub, "write", "tmp", "b", "1")
+	test_invoke(t, stub, "write", "tmp", "c", "1", TYPE_STRING, "2023-11-15T00:00:00Z")
+	test_invoke(t, stub, "write", "tmp", "d", "1")
+	stub.seconds += 7 * 24 * 60 * 60
+
+	tests := []struct {
+		args []string
+		want DeletedKeys
+	}{
+		{args: []string{"tmp", "1", "b"}, want: DeletedKeys{Keys: []string{}, NextKey: "c"}},
+		{args: []string{"tmp", "2"}, want: DeletedKeys{Keys: []string{"a"}, NextKey: "c"}},
+		{args: []string{"tmp", "2", "c"}, want: DeletedKeys{Keys: []string{"c"}}},
+		{args: []string{"tmp"}, want: DeletedKeys{Keys: []string{}}},
+	}
+	for _, test := range tests {
+		var got DeletedKeys
+		json.Unmarshal(test_invoke(t, stub, "purge_expired", test.args...), &got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("purge_expired %q = %+v, want %+v", test.args, got, test.want)
+		}
+	}
+}
+
+func TestNextEntry(t *testing.T) {
+	owned := &Entry{Value: "a", Type: TYPE_STRING, Version: 3, Owner: "alice", Readers: []string{"bob"}, Writers: []string{"carol"}, ExpiresAt: "2030-01-01T00:00:00Z"}
+	legacy := &Entry{Value: "a", Type: TYPE_STRING}
+
+	tests := []struct {
+		name    string
+		current *Entry
+		writer  string
+		want    Entry
+	}{
+		{name: "new key", current: nil, writer: "carol", want: Entry{Value: "b", Type: TYPE_INT, Version: 1, Owner: "carol"}},
+		{name: "owned key", current: owned, writer: "carol", want: Entry{Value: "b", Type: TYPE_INT, Version: 4, Owner: "alice", Readers: []string{"bob"}, Writers: []string{"carol"}}},
+		{name: "key written before versions", current: legacy, writer: "carol", want: Entry{Value: "b", Type: TYPE_INT, Version: 1, Owner: "carol"}},
+		{name: "deploy without identity", current: nil, writer: "", want: Entry{Value: "b", Type: TYPE_INT, Version: 1}},
+	}
+	for _, test := range tests {
+		if got := next_entry("b", TYPE_INT, "", test.current, test.writer); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: next_entry = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
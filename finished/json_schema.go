@@ -0,0 +1,323 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+// int_pattern - ints are written in decimal without a plus sign or leading zeros
+var int_pattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+
+// decimal_pattern - decimals are written in fixed point, without an exponent
+var decimal_pattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?$`)
+
+// schema_keywords - the JSON schema keywords that are checked, and the annotations that are accepted but not checked
+var schema_keywords = map[string]bool{
+	"type": true, "enum": true, "const": true,
+	"properties": true, "required": true, "additionalProperties": true,
+	"items": true, "minItems": true, "maxItems": true,
+	"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+	"minLength": true, "maxLength": true, "pattern": true,
+	"$schema": true, "$id": true, "title": true, "description": true, "default": true, "examples": true,
+}
+
+// schema_types - the JSON schema types
+var schema_types = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true, "string": true, "number": true, "integer": true,
+}
+
+// decode_json - decodes JSON keeping numbers as json.Number so ints keep their precision
+func decode_json(value string) (interface{}, error) {
+	var decoded interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.UseNumber()
+	err := decoder.Decode(&decoded)
+	if err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return decoded, nil
+}
+
+// check_schema - fails when a schema uses a keyword that is not checked, so a schema is never silently weaker
+// than it reads
+func check_schema(schema interface{}, path string) error {
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+	definition, ok := schema.(map[string]interface{})
+	if !ok {
+		return errors.New("schema" + path + " must be an object or a bool")
+	}
+
+	for keyword, value := range definition {
+		if !schema_keywords[keyword] {
+			return errors.New("schema" + path + " uses unsupported keyword " + keyword)
+		}
+		switch keyword {
+		case "properties":
+			properties, ok := value.(map[string]interface{})
+			if !ok {
+				return errors.New("schema" + path + ": properties must be an object")
+			}
+			for name, property := range properties {
+				err := check_schema(property, path+"/properties/"+name)
+				if err != nil {
+					return err
+				}
+			}
+		case "additionalProperties", "items":
+			err := check_schema(value, path+"/"+keyword)
+			if err != nil {
+				return err
+			}
+		case "type":
+			types, ok := value.([]interface{})
+			if !ok {
+				types = []interface{}{value}
+			}
+			for _, t := range types {
+				if name, ok := t.(string); !ok || !schema_types[name] {
+					return errors.New("schema" + path + ": unknown type " + describe(t))
+				}
+			}
+		case "enum":
+			if _, ok := value.([]interface{}); !ok {
+				return errors.New("schema" + path + ": enum must be a list")
+			}
+		case "required":
+			names, ok := value.([]interface{})
+			if !ok {
+				return errors.New("schema" + path + ": required must be a list of property names")
+			}
+			for _, name := range names {
+				if _, ok := name.(string); !ok {
+					return errors.New("schema" + path + ": required must be a list of property names")
+				}
+			}
+		case "pattern":
+			pattern, ok := value.(string)
+			if !ok {
+				return errors.New("schema" + path + ": pattern must be a string")
+			}
+			if _, err := regexp.Compile(pattern); err != nil {
+				return errors.New("schema" + path + ": invalid pattern " + pattern)
+			}
+		case "minItems", "maxItems", "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "minLength", "maxLength":
+			if _, ok := number(value); !ok {
+				return errors.New("schema" + path + ": " + keyword + " must be a number")
+			}
+		}
+	}
+	return nil
+}
+
+// validate_schema - validates a decoded JSON value against a schema accepted by check_schema
+func validate_schema(schema interface{}, value interface{}, path string) error {
+	if allowed, ok := schema.(bool); ok {
+		if !allowed {
+			return errors.New(location(path) + " is not allowed")
+		}
+		return nil
+	}
+	definition := schema.(map[string]interface{})
+
+	if types, found := definition["type"]; found && !has_type(types, value) {
+		return errors.New(location(path) + " is not of type " + describe(types))
+	}
+	if options, found := definition["enum"].([]interface{}); found {
+		matched := false
+		for _, option := range options {
+			matched = matched || json_equal(option, value)
+		}
+		if !matched {
+			return errors.New(location(path) + " is not one of " + describe(options))
+		}
+	}
+	if constant, found := definition["const"]; found && !json_equal(constant, value) {
+		return errors.New(location(path) + " is not " + describe(constant))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if required, found := definition["required"].([]interface{}); found {
+			for _, name := range required {
+				if _, present := v[name.(string)]; !present {
+					return errors.New(location(path) + " is missing property " + name.(string))
+				}
+			}
+		}
+		properties, _ := definition["properties"].(map[string]interface{})
+		for name, property := range v {
+			if propertySchema, found := properties[name]; found {
+				err := validate_schema(propertySchema, property, path+"/"+name)
+				if err != nil {
+					return err
+				}
+			} else if additional, found := definition["additionalProperties"]; found {
+				err := validate_schema(additional, property, path+"/"+name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if limit, found := number(definition["minItems"]); found && float64(len(v)) < limit {
+			return errors.New(location(path) + " has fewer than " + describe(definition["minItems"]) + " items")
+		}
+		if limit, found := number(definition["maxItems"]); found && float64(len(v)) > limit {
+			return errors.New(location(path) + " has more than " + describe(definition["maxItems"]) + " items")
+		}
+		if items, found := definition["items"]; found {
+			for i, item := range v {
+				err := validate_schema(items, item, path+"/"+strconv.Itoa(i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case json.Number:
+		n, _ := number(v)
+		if limit, found := number(definition["minimum"]); found && n < limit {
+			return errors.New(location(path) + " is less than " + describe(definition["minimum"]))
+		}
+		if limit, found := number(definition["maximum"]); found && n > limit {
+			return errors.New(location(path) + " is greater than " + describe(definition["maximum"]))
+		}
+		if limit, found := number(definition["exclusiveMinimum"]); found && n <= limit {
+			return errors.New(location(path) + " is not greater than " + describe(definition["exclusiveMinimum"]))
+		}
+		if limit, found := number(definition["exclusiveMaximum"]); found && n >= limit {
+			return errors.New(location(path) + " is not less than " + describe(definition["exclusiveMaximum"]))
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if limit, found := number(definition["minLength"]); found && length < limit {
+			return errors.New(location(path) + " is shorter than " + describe(definition["minLength"]) + " characters")
+		}
+		if limit, found := number(definition["maxLength"]); found && length > limit {
+			return errors.New(location(path) + " is longer than " + describe(definition["maxLength"]) + " characters")
+		}
+		if pattern, found := definition["pattern"].(string); found && !regexp.MustCompile(pattern).MatchString(v) {
+			return errors.New(location(path) + " does not match " + pattern)
+		}
+	}
+	return nil
+}
+
+// has_type - whether a value is of a JSON schema type, or of one of a list of types
+func has_type(types interface{}, value interface{}) bool {
+	if list, ok := types.([]interface{}); ok {
+		for _, t := range list {
+			if has_type(t, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch types {
+	case "null":
+		return value == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		n, ok := number(value)
+		return ok && n == math.Trunc(n)
+	}
+	return false
+}
+
+// json_equal - whether two decoded JSON values are equal, comparing numbers by value
+func json_equal(a interface{}, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, value := range x {
+			if other, found := y[name]; !found || !json_equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !json_equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// number - the value of a decoded JSON number
+func number(value interface{}) (float64, bool) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+// describe - a decoded JSON value as it is written in error messages
+func describe(value interface{}) string {
+	valAsbytes, _ := json.Marshal(value)
+	return string(valAsbytes)
+}
+
+// location - where in a value a validation error is, the value itself when the path is empty
+func location(path string) string {
+	if path == "" {
+		return "value"
+	}
+	return "value" + path
+}
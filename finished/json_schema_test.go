@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	tests := []struct {
+		schema string
+		valid  bool
+	}{
+		{schema: `true`, valid: true},
+		{schema: `{}`, valid: true},
+		{schema: `{"type":"object","properties":{"n":{"type":["integer","null"],"minimum":0}},"required":["n"],"additionalProperties":false}`, valid: true},
+		{schema: `{"title":"t","description":"d","items":{"type":"string","pattern":"^[a-z]+$"},"maxItems":3}`, valid: true},
+		{schema: `[]`, valid: false},
+		{schema: `{"format":"email"}`, valid: false},
+		{schema: `{"properties":{"n":{"oneOf":[]}}}`, valid: false},
+		{schema: `{"type":"int"}`, valid: false},
+		{schema: `{"enum":"a"}`, valid: false},
+		{schema: `{"required":[1]}`, valid: false},
+		{schema: `{"pattern":"("}`, valid: false},
+		{schema: `{"minLength":"1"}`, valid: false},
+	}
+	for _, test := range tests {
+		schema, _ := decode_json(test.schema)
+		err := check_schema(schema, "")
+		if (err == nil) != test.valid {
+			t.Errorf("check_schema(%s) = %v, want valid %v", test.schema, err, test.valid)
+		}
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	person := `{"type":"object","properties":{"name":{"type":"string","minLength":1,"maxLength":5},"age":{"type":"integer","minimum":0,"exclusiveMaximum":150}},"required":["name"],"additionalProperties":false}`
+
+	tests := []struct {
+		schema string
+		value  string
+		valid  bool
+	}{
+		{schema: `true`, value: `1`, valid: true},
+		{schema: `false`, value: `1`, valid: false},
+		{schema: person, value: `{"name":"ann","age":30}`, valid: true},
+		{schema: person, value: `{"age":30}`, valid: false},
+		{schema: person, value: `{"name":"ann","pet":"cat"}`, valid: false},
+		{schema: person, value: `{"name":"annabel"}`, valid: false},
+		{schema: person, value: `{"name":"ann","age":150}`, valid: false},
+		{schema: person, value: `{"name":"ann","age":1.5}`, valid: false},
+		{schema: person, value: `{"name":"ann","age":30.0}`, valid: true},
+		{schema: `{"type":["string","null"]}`, value: `null`, valid: true},
+		{schema: `{"type":"number"}`, value: `"1"`, valid: false},
+		{schema: `{"enum":[1,"a",{"b":[true]}]}`, value: `1.0`, valid: true},
+		{schema: `{"enum":[1,"a",{"b":[true]}]}`, value: `{"b":[true]}`, valid: true},
+		{schema: `{"enum":[1,"a",{"b":[true]}]}`, value: `{"b":[false]}`, valid: false},
+		{schema: `{"const":"a"}`, value: `"b"`, valid: false},
+		{schema: `{"items":{"type":"integer"},"minItems":1,"maxItems":2}`, value: `[1,2]`, valid: true},
+		{schema: `{"items":{"type":"integer"},"minItems":1,"maxItems":2}`, value: `[]`, valid: false},
+		{schema: `{"items":{"type":"integer"},"minItems":1,"maxItems":2}`, value: `[1,"2"]`, valid: false},
+		{schema: `{"pattern":"^[a-z]+$"}`, value: `"abc"`, valid: true},
+		{schema: `{"pattern":"^[a-z]+$"}`, value: `"ABC"`, valid: false},
+		{schema: `{"minLength":2}`, value: `"é"`, valid: false},
+	}
+	for _, test := range tests {
+		schema, _ := decode_json(test.schema)
+		value, _ := decode_json(test.value)
+		err := validate_schema(schema, value, "")
+		if (err == nil) != test.valid {
+			t.Errorf("validate_schema(%s, %s) = %v, want valid %v", test.schema, test.value, err, test.valid)
+		}
+	}
+}
+
+func TestJSONEqual(t *testing.T) {
+	tests := []struct {
+		a     string
+		b     string
+		equal bool
+	}{
+		{a: `1`, b: `1.0`, equal: true},
+		{a: `1`, b: `"1"`, equal: false},
+		{a: `"a"`, b: `"a"`, equal: true},
+		{a: `null`, b: `false`, equal: false},
+		{a: `[1,[2]]`, b: `[1,[2.0]]`, equal: true},
+		{a: `[1,2]`, b: `[2,1]`, equal: false},
+		{a: `{"a":1,"b":[true]}`, b: `{"b":[true],"a":1e0}`, equal: true},
+		{a: `{"a":1}`, b: `{"a":1,"b":null}`, equal: false},
+		{a: `{"a":1}`, b: `[1]`, equal: false},
+	}
+	for _, test := range tests {
+		a, _ := decode_json(test.a)
+		b, _ := decode_json(test.b)
+		if got := json_equal(a, b); got != test.equal {
+			t.Errorf("json_equal(%s, %s) = %v, want %v", test.a, test.b, got, test.equal)
+		}
+	}
+}
+
+func TestTypedValue(t *testing.T) {
+	tests := []struct {
+		valueType string
+		value     string
+		want      interface{}
+		valid     bool
+	}{
+		{valueType: TYPE_STRING, value: "007", want: "007", valid: true},
+		{valueType: TYPE_INT, value: "-42", want: json.Number("-42"), valid: true},
+		{valueType: TYPE_INT, value: "9223372036854775807", want: json.Number("9223372036854775807"), valid: true},
+		{valueType: TYPE_INT, value: "9223372036854775808", valid: false},
+		{valueType: TYPE_INT, value: "007", valid: false},
+		{valueType: TYPE_INT, value: "+1", valid: false},
+		{valueType: TYPE_INT, value: "1.0", valid: false},
+		{valueType: TYPE_DECIMAL, value: "-0.50", want: json.Number("-0.50"), valid: true},
+		{valueType: TYPE_DECIMAL, value: "1e3", valid: false},
+		{valueType: TYPE_BOOL, value: "true", want: true, valid: true},
+		{valueType: TYPE_BOOL, value: "TRUE", valid: false},
+		{valueType: TYPE_JSON, value: `{"a":[1]}`, want: map[string]interface{}{"a": []interface{}{json.Number("1")}}, valid: true},
+		{valueType: TYPE_JSON, value: `{"a":1} {}`, valid: false},
+		{valueType: "float", value: "1", valid: false},
+	}
+	for _, test := range tests {
+		got, err := typed_value(test.valueType, test.value)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("typed_value(%s, %q) = %v, want an error", test.valueType, test.value, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("typed_value(%s, %q) = %#v, %v, want %#v", test.valueType, test.value, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// DEFAULT_NAMESPACE - namespace of the keys written before namespaces, and of hello_world. Its keys are stored
+// under their own name and it is open to everyone until an admin updates its ACL.
+const DEFAULT_NAMESPACE = "default"
+
+// NAMESPACE_PREFIX - prefix of the namespace records
+const NAMESPACE_PREFIX = RESERVED_PREFIX + "namespace\x00"
+
+// NAMESPACE_KEY_PREFIX - prefix of the keys of every namespace but the default one, followed by the name of the namespace
+const NAMESPACE_KEY_PREFIX = RESERVED_PREFIX + "ns\x00"
+
+// ANYONE - in the ACL of a namespace, gives every caller access
+const ANYONE = "*"
+
+// namespace_pattern - names of namespaces
+var namespace_pattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Namespace - a key space with the identities that can read and write it, and the quota on its number of keys
+// and their total bytes, 0 for no quota. Keys and Bytes are its current usage.
+type Namespace struct {
+	Name      string   `json:"Name"`
+	Readers   []string `json:"Readers"`
+	Writers   []string `json:"Writers"`
+	MaxKeys   int      `json:"MaxKeys"`
+	MaxBytes  int      `json:"MaxBytes"`
+	Keys      int      `json:"Keys"`
+	Bytes     int      `json:"Bytes"`
+	CreatedBy string   `json:"CreatedBy"`
+}
+
+// create_namespace - invoke function for an admin to create a namespace with its JSON lists of readers and writers,
+// and optionally its quotas on keys and bytes
+func (t *SimpleChaincode) create_namespace(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running create_namespace()")
+
+	if len(args) < 3 || len(args) > 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name, JSON list of readers, JSON list of writers, and optionally the maximum keys and bytes")
+	}
+	if !namespace_pattern.MatchString(args[0]) {
+		return nil, errors.New("Invalid namespace name " + args[0] + ", expecting letters, digits, _, . and -")
+	}
+
+	caller, err := t.get_admin(stub, "create namespaces")
+	if err != nil {
+		return nil, err
+	}
+	existing, err := stub.GetState(NAMESPACE_PREFIX + args[0])
+	if err != nil {
+		return nil, errors.New("Failed to get namespace " + args[0])
+	}
+	if existing != nil || args[0] == DEFAULT_NAMESPACE {
+		return nil, errors.New("Namespace " + args[0] + " already exists")
+	}
+
+	ns := Namespace{Name: args[0], CreatedBy: caller}
+	err = set_namespace_limits(&ns, args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return nil, t.save_namespace(stub, &ns)
+}
+
+// update_namespace - invoke function for an admin to replace the readers, writers and quotas of a namespace
+func (t *SimpleChaincode) update_namespace(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running update_namespace()")
+
+	if len(args) < 3 || len(args) > 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name, JSON list of readers, JSON list of writers, and optionally the maximum keys and bytes")
+	}
+
+	_, err := t.get_admin(stub, "update namespaces")
+	if err != nil {
+		return nil, err
+	}
+	ns, err := t.get_namespace(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = set_namespace_limits(ns, args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return nil, t.save_namespace(stub, ns)
+}
+
+// read_namespace - query function to read the ACL, quotas and usage of a namespace
+func (t *SimpleChaincode) read_namespace(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running read_namespace()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting name of the namespace")
+	}
+	ns, _, err := t.get_scope(stub, args, false)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ns)
+}
+
+// set_namespace_limits - sets the readers, writers and quotas of a namespace from their arguments
+func set_namespace_limits(ns *Namespace, args []string) error {
+	var readers, writers []string
+
+	err := json.Unmarshal([]byte(args[0]), &readers)
+	if err != nil {
+		return errors.New("Readers must be a JSON list of identities")
+	}
+	err = json.Unmarshal([]byte(args[1]), &writers)
+	if err != nil {
+		return errors.New("Writers must be a JSON list of identities")
+	}
+
+	quotas := []int{0, 0}
+	for i, quota := range args[2:] {
+		if quota == "" {
+			continue
+		}
+		quotas[i], err = strconv.Atoi(quota)
+		if err != nil || quotas[i] < 0 {
+			return errors.New("Invalid quota " + quota + ", expecting a non negative int")
+		}
+	}
+	// The usage of the default namespace is not known for the keys written before namespaces.
+	if ns.Name == DEFAULT_NAMESPACE && (quotas[0] != 0 || quotas[1] != 0) {
+		return errors.New("The " + DEFAULT_NAMESPACE + " namespace can not have quotas")
+	}
+
+	ns.Readers = readers
+	ns.Writers = writers
+	ns.MaxKeys = quotas[0]
+	ns.MaxBytes = quotas[1]
+	return nil
+}
+
+// get_scope - takes the namespace from the first argument after checking the caller can read it, or write it
+// when write is set, and returns it with the remaining arguments. Admins can use every namespace.
+func (t *SimpleChaincode) get_scope(stub shim.ChaincodeStubInterface, args []string, write bool) (*Namespace, []string, error) {
+	if len(args) < 1 {
+		return nil, nil, errors.New("Incorrect number of arguments. Expecting a namespace first")
+	}
+
+	caller, err := t.get_caller(stub)
+	if err != nil {
+		return nil, nil, err
+	}
+	ns, err := t.get_namespace(stub, args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allowed := t.is_admin(stub) || contains(ns.Writers, caller) || contains(ns.Writers, ANYONE)
+	if !write {
+		allowed = allowed || contains(ns.Readers, caller) || contains(ns.Readers, ANYONE)
+	}
+	if !allowed {
+		return nil, nil, errors.New("Permission denied. " + caller + " can not use namespace " + args[0])
+	}
+	return ns, args[1:], nil
+}
+
+// get_namespace - reads a namespace record, the default namespace is open to everyone until it has a record
+func (t *SimpleChaincode) get_namespace(stub shim.ChaincodeStubInterface, name string) (*Namespace, error) {
+	var ns Namespace
+
+	nsAsbytes, err := stub.GetState(NAMESPACE_PREFIX + name)
+	if err != nil {
+		return nil, errors.New("Failed to get namespace " + name)
+	}
+	if nsAsbytes == nil {
+		if name == DEFAULT_NAMESPACE {
+			return &Namespace{Name: DEFAULT_NAMESPACE, Readers: []string{ANYONE}, Writers: []string{ANYONE}}, nil
+		}
+		return nil, errors.New("Namespace " + name + " does not exist")
+	}
+
+	err = json.Unmarshal(nsAsbytes, &ns)
+	if err != nil {
+		return nil, errors.New("Failed to decode namespace " + name)
+	}
+	return &ns, nil
+}
+
+// save_namespace - writes a namespace record
+func (t *SimpleChaincode) save_namespace(stub shim.ChaincodeStubInterface, ns *Namespace) error {
+	nsAsbytes, err := json.Marshal(ns)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(NAMESPACE_PREFIX+ns.Name, nsAsbytes)
+}
+
+// update_usage - adds to the usage of a namespace, failing when a growing usage is over its quota. Removing keys
+// always succeeds so a namespace over a lowered quota can be cleaned up.
+func (t *SimpleChaincode) update_usage(stub shim.ChaincodeStubInterface, ns *Namespace, keys int, bytes int) error {
+	if ns.Name == DEFAULT_NAMESPACE || (keys == 0 && bytes == 0) {
+		return nil
+	}
+
+	ns.Keys += keys
+	ns.Bytes += bytes
+	if keys > 0 && ns.MaxKeys > 0 && ns.Keys > ns.MaxKeys {
+		return errors.New("Namespace " + ns.Name + " is over its quota of " + strconv.Itoa(ns.MaxKeys) + " keys")
+	}
+	if bytes > 0 && ns.MaxBytes > 0 && ns.Bytes > ns.MaxBytes {
+		return errors.New("Namespace " + ns.Name + " is over its quota of " + strconv.Itoa(ns.MaxBytes) + " bytes")
+	}
+	return t.save_namespace(stub, ns)
+}
+
+// entry_size - the bytes an entry counts against the quota of its namespace, its key and value
+func entry_size(key string, entry *Entry) int {
+	return len(key) + len(entry.Value)
+}
+
+// state_key - the key in the state of a key of a namespace
+func state_key(ns *Namespace, key string) string {
+	if ns.Name == DEFAULT_NAMESPACE {
+		return key
+	}
+	return NAMESPACE_KEY_PREFIX + ns.Name + "\x00" + key
+}
+
+// namespace_key - the key of a namespace stored at a state key, not found for the chaincode's own records
+// and the keys of other namespaces
+func namespace_key(ns *Namespace, stateKey string) (string, bool) {
+	if ns.Name == DEFAULT_NAMESPACE {
+		return stateKey, !strings.HasPrefix(stateKey, RESERVED_PREFIX)
+	}
+	prefix := state_key(ns, "")
+	return strings.TrimPrefix(stateKey, prefix), strings.HasPrefix(stateKey, prefix)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetScope(t *testing.T) {
+	stub := new_test_stub(t).caller("root", ADMIN)
+	test_invoke(t, stub, "create_namespace", "team", `["reader"]`, `["writer"]`)
+	test_invoke(t, stub, "create_namespace", "public", `["*"]`, `[]`)
+
+	tests := []struct {
+		caller    string
+		role      string
+		namespace string
+		write     bool
+		allowed   bool
+	}{
+		{caller: "root", role: ADMIN, namespace: "team", write: true, allowed: true},
+		{caller: "writer", role: "user", namespace: "team", write: true, allowed: true},
+		{caller: "writer", role: "user", namespace: "team", write: false, allowed: true},
+		{caller: "reader", role: "user", namespace: "team", write: false, allowed: true},
+		{caller: "reader", role: "user", namespace: "team", write: true, allowed: false},
+		{caller: "stranger", role: "user", namespace: "team", write: false, allowed: false},
+		{caller: "stranger", role: "user", namespace: "public", write: false, allowed: true},
+		{caller: "stranger", role: "user", namespace: "public", write: true, allowed: false},
+		{caller: "stranger", role: "user", namespace: DEFAULT_NAMESPACE, write: true, allowed: true},
+		{caller: "stranger", role: "user", namespace: "missing", write: false, allowed: false},
+		{caller: "", role: ADMIN, namespace: "team", write: false, allowed: false},
+	}
+	for _, test := range tests {
+		stub.attributes = map[string]string{"role": test.role}
+		if test.caller != "" {
+			stub.attributes["username"] = test.caller
+		}
+
+		ns, args, err := new(SimpleChaincode).get_scope(stub, []string{test.namespace, "key"}, test.write)
+		if !test.allowed {
+			if err == nil {
+				t.Errorf("%q in %s, write %v: allowed, want denied", test.caller, test.namespace, test.write)
+			}
+			continue
+		}
+		if err != nil || ns.Name != test.namespace || len(args) != 1 || args[0] != "key" {
+			t.Errorf("%q in %s, write %v: %v, %v, %v", test.caller, test.namespace, test.write, ns, args, err)
+		}
+	}
+}
+
+func TestInvokeInitNeedsDefaultNamespace(t *testing.T) {
+	stub := new_test_stub(t).caller("root", ADMIN)
+	test_invoke(t, stub, "update_namespace", DEFAULT_NAMESPACE, `["*"]`, `["root"]`)
+
+	stub.caller("mallory", "user")
+	_, err := new(SimpleChaincode).Invoke(stub, "init", []string{"taken"})
+	if err == nil {
+		t.Error("init invoked by a caller that can not write the default namespace succeeded")
+	}
+
+	stub.caller("root", ADMIN)
+	test_invoke(t, stub, "init", "reset")
+	entry, _ := new(SimpleChaincode).get_entry(stub, "hello_world")
+	if entry == nil || entry.Value != "reset" {
+		t.Errorf("hello_world = %+v, want reset", entry)
+	}
+}
+
+func TestStateKeys(t *testing.T) {
+	defaultNamespace := &Namespace{Name: DEFAULT_NAMESPACE}
+	team := &Namespace{Name: "team"}
+
+	tests := []struct {
+		ns       *Namespace
+		key      string
+		stateKey string
+	}{
+		{ns: defaultNamespace, key: "a", stateKey: "a"},
+		{ns: defaultNamespace, key: "", stateKey: ""},
+		{ns: team, key: "a", stateKey: NAMESPACE_KEY_PREFIX + "team\x00a"},
+		{ns: team, key: "", stateKey: NAMESPACE_KEY_PREFIX + "team\x00"},
+	}
+	for _, test := range tests {
+		stateKey := state_key(test.ns, test.key)
+		if stateKey != test.stateKey {
+			t.Errorf("state_key(%s, %q) = %q, want %q", test.ns.Name, test.key, stateKey, test.stateKey)
+		}
+		key, found := namespace_key(test.ns, stateKey)
+		if key != test.key || !found {
+			t.Errorf("namespace_key(%s, %q) = %q, %v, want %q", test.ns.Name, stateKey, key, found, test.key)
+		}
+	}
+
+	foreign := []struct {
+		ns       *Namespace
+		stateKey string
+	}{
+		{ns: defaultNamespace, stateKey: HISTORY_PREFIX + "a"},
+		{ns: defaultNamespace, stateKey: NAMESPACE_KEY_PREFIX + "team\x00a"},
+		{ns: team, stateKey: "a"},
+		{ns: team, stateKey: NAMESPACE_KEY_PREFIX + "teams\x00a"},
+		{ns: team, stateKey: NAMESPACE_PREFIX + "team"},
+	}
+	for _, test := range foreign {
+		if key, found := namespace_key(test.ns, test.stateKey); found {
+			t.Errorf("namespace_key(%s, %q) = %q, want not found", test.ns.Name, test.stateKey, key)
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"strconv"
+	"time"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"encoding/json"
 )
@@ -16,7 +18,7 @@ import (
 //==============================================================================================================================
 //	 Participating Entities
 //==============================================================================================================================
-/*const   FEDERAL_RESERVE   =  "federal_reserve"
+const   FEDERAL_RESERVE   =  "federal_reserve"
 const   CUSTOMER          =  "customer"
 const   LENDING_BANK      =  "lendor"
 const   PARTNER_BANK      =  "partner_bank"
@@ -24,7 +26,9 @@ const   AUDITOR           =  "auditor"
 const   GSE               =  "gse"
 const   BROKER            =  "broker"
 const   CITY_COUNCIL      =   "city_council"
-const   DATA_PROVIDER    =   "data_service_provider"*/
+const   DATA_PROVIDER    =   "data_service_provider"
+const   APPRAISER         =   "appraiser"
+const   ADMIN             =   "admin"
 
 
 //==============================================================================================================================
@@ -45,14 +49,13 @@ type SimpleChaincode struct {
 //			  that element when reading a JSON object into the struct e.g. JSON customerName -> Struct customer Name.
 //==============================================================================================================================
 type Mortgage struct {
-	CustomerName               string  `json:"CustomerName"`
-	CustomerAddress            string  `json:"CustomerAddress"`
-	CustomerSSN                int     `json:"CustomerSSN"`
-	CustomerDOB                string  `json:"CustomerDOB"`
+	SchemaVersion              int     `json:"SchemaVersion"`
+	CustomerID                 string  `json:"CustomerID"`
+	BrokerID                   string  `json:"BrokerID"`
 	MortgageNumber             int     `json:"MortgageNumber"`
 	MortgageStage              string  `json:"MortgageStage"`
 	MortgagePropertyOwnership  string  `json:"MortgagePropertyOwnership"`
-	MortgagePropertyAddress    string  `json:"MortgagePropertyAddress"`
+	PropertyID                 string  `json:"PropertyID"`
 	ReqLoanAmount              int     `json:"ReqLoanAmount"`
 	GrantedLoanAmount          int     `json:"GrantedLoanAmount"`
 	MortgageType               string  `json:"MortgageType"`
@@ -69,7 +72,45 @@ type Mortgage struct {
 	RemainingMortgageAmount    int     `json:"RemainingMortgageAmount"`
 	Ownershipcost              int     `json:"Ownershipcost"`
 	ConformedMortgage          bool    `json:"ConformedMortgage"`
+	AppraisalIDs               []string `json:"AppraisalIDs"`
+	AcceptedAppraisalID        string  `json:"AcceptedAppraisalID"`
+	AdjustableRate             *AdjustableRateTerms `json:"AdjustableRate,omitempty"`
+	Payments                   []PaymentRecord `json:"Payments"`
+	ApprovedMaxAmount          int     `json:"ApprovedMaxAmount"`
+	DocumentIDs                []string `json:"DocumentIDs"`
+	DeferredPrincipal          int     `json:"DeferredPrincipal"`
+	TermsVersion               int     `json:"TermsVersion"`
+	Modifications              []LoanModification `json:"Modifications"`
+	DayCountConvention         string  `json:"DayCountConvention"`
+	InterestAccruedTo          string  `json:"InterestAccruedTo"`
+	AccruedInterest            int     `json:"AccruedInterest"`
+	OutstandingFees            int     `json:"OutstandingFees"`
+	Fees                       []FeeRecord `json:"Fees"`
+	StageHistory               []StageEntry `json:"StageHistory"`
 	ModifiedBy                 string  `json:"ModifiedBy"`
+	Legacy                     *LegacyParties `json:"-"` //Set on mortgages upgraded from version 1 until they are saved
+}
+
+//==============================================================================================================================
+//	PaymentRecord - A payment received on a disbursed mortgage and the part of it applied to the principal.
+//==============================================================================================================================
+type PaymentRecord struct {
+	Amount                     int     `json:"Amount"`
+	Principal                  int     `json:"Principal"`
+	Interest                   int     `json:"Interest"`
+	Fees                       int     `json:"Fees"`
+	Escrow                     int     `json:"Escrow"`
+	TxID                       string  `json:"TxID"`
+	PaymentDate                string  `json:"PaymentDate"`
+}
+
+//==============================================================================================================================
+//	StageEntry - The time a mortgage entered a stage, taken from the timestamp of the transaction that moved it there.
+//==============================================================================================================================
+type StageEntry struct {
+	Stage                      string  `json:"Stage"`
+	EnteredAt                  string  `json:"EnteredAt"`
+	TxID                       string  `json:"TxID"`
 }
 
 //==============================================================================================================================
@@ -79,10 +120,11 @@ type Mortgage struct {
 
 type mortgage_portfolio struct {
 	MortgageNumbers     			  []int    `json:"MortgageNumbers"`
-	CustomerNames       			  []string `json:"CustomerNames"`
+	CustomerIDs         			  []string `json:"CustomerIDs"`
 	MortgageStages      			  []string `json:"MortgageStages"`
 	ConformedMortgages				  []bool   `json:"ConformedMortgages"`
 	MortgagePropertyOwnerships  []string `json:"MortgagePropertyOwnerships"`
+	CustomerNames       			  []string `json:"CustomerNames,omitempty"` //Portfolios stored before customers had ids
 }
 // ============================================================================================================================
 // Main
@@ -124,6 +166,62 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function stri
      return t.create_mortgage_application(stub, args)
   } else if function == "modify_mortgage" {
      return t.modify_mortgage(stub, args)
+  } else if function == "create_customer" {
+     return t.create_customer(stub, args)
+  } else if function == "update_customer_kyc" {
+     return t.update_customer_kyc(stub, args)
+  } else if function == "add_customer_contact" {
+     return t.add_customer_contact(stub, args)
+  } else if function == "register_property" {
+     return t.register_property(stub, args)
+  } else if function == "update_property" {
+     return t.update_property(stub, args)
+  } else if function == "add_property_valuation" {
+     return t.add_property_valuation(stub, args)
+  } else if function == "order_appraisal" {
+     return t.order_appraisal(stub, args)
+  } else if function == "submit_appraisal" {
+     return t.submit_appraisal(stub, args)
+  } else if function == "review_appraisal" {
+     return t.review_appraisal(stub, args)
+  } else if function == "register_data_provider" {
+     return t.register_data_provider(stub, args)
+  } else if function == "revoke_data_provider" {
+     return t.revoke_data_provider(stub, args)
+  } else if function == "submit_credit_report" {
+     return t.submit_credit_report(stub, args)
+  } else if function == "publish_rate_index" {
+     return t.publish_rate_index(stub, args)
+  } else if function == "reset_rates" {
+     return t.reset_rates(stub, args)
+  } else if function == "set_commission_schedule" {
+     return t.set_commission_schedule(stub, args)
+  } else if function == "pay_commission" {
+     return t.pay_commission(stub, args)
+  } else if function == "open_underwriting" {
+     return t.open_underwriting(stub, args)
+  } else if function == "add_underwriting_condition" {
+     return t.add_underwriting_condition(stub, args)
+  } else if function == "satisfy_underwriting_condition" {
+     return t.satisfy_underwriting_condition(stub, args)
+  } else if function == "decide_underwriting" {
+     return t.decide_underwriting(stub, args)
+  } else if function == "attach_document" {
+     return t.attach_document(stub, args)
+  } else if function == "set_escrow" {
+     return t.set_escrow(stub, args)
+  } else if function == "disburse_escrow" {
+     return t.disburse_escrow(stub, args)
+  } else if function == "modify_loan" {
+     return t.modify_loan(stub, args)
+  } else if function == "grant_forbearance" {
+     return t.grant_forbearance(stub, args)
+  } else if function == "accrue_interest" {
+     return t.accrue_interest(stub, args)
+  } else if function == "assess_fee" {
+     return t.assess_fee(stub, args)
+  } else if function == "migrate_mortgages" {
+     return t.migrate_mortgages(stub, args)
   }
 
 	fmt.Println("invoke did not find func: " + function)					//error
@@ -154,14 +252,33 @@ func (t *SimpleChaincode) create_mortgage_application(stub shim.ChaincodeStubInt
 		}
 
 		//Get latest mortgages porfolio in blockchain and assign it to variable array
-		bytes, err = stub.GetState("mortgages")
+		mortgages, err = t.get_mortgage_portfolio(stub)
 		if err != nil {
-			  return nil, errors.New("error while retrieving mortgage portfolio json object")
+			  return nil, err
 		}
-		err = json.Unmarshal(bytes,&mortgages)
+
+		//Mortgage must reference a registered customer
+		customer, err := t.get_customer(stub, mortgage.CustomerID)
 		if err != nil {
- 			  return nil, errors.New("error while Unmarshalling mortgages for new mortgage number")
- 		}
+			  return nil, err
+		}
+
+		//Brokers originate applications on behalf of customers and are recorded on the mortgage
+		username, role, err := t.get_caller_data(stub)
+		if err != nil {
+			  return nil, err
+		}
+		if role == BROKER {
+			  mortgage.BrokerID = username
+		}else{
+			  mortgage.BrokerID = ""
+		}
+
+		//Mortgage must reference a property registered by the city council
+		_, err = t.get_property(stub, mortgage.PropertyID)
+		if err != nil {
+			  return nil, err
+		}
 
 		// Generate Unique mortgage number and append to Mortgage portfolio
 		if len(mortgages.MortgageNumbers) > 0 {
@@ -174,13 +291,48 @@ func (t *SimpleChaincode) create_mortgage_application(stub shim.ChaincodeStubInt
 		mortgage.MortgageStage="Pending-Bank:"
 		mortgage.ConformedMortgage=false
 		mortgage.MortgagePropertyOwnership="NOT_ACCQUIRED"
+		mortgage.PropertyValuation=0
+		mortgage.AppraisalIDs=nil
+		mortgage.AcceptedAppraisalID=""
+		mortgage.Payments=nil
+		mortgage.ApprovedMaxAmount=0
+		mortgage.DocumentIDs=nil
+		mortgage.DeferredPrincipal=0
+		mortgage.TermsVersion=0
+		mortgage.Modifications=nil
+		mortgage.InterestAccruedTo=""
+		mortgage.AccruedInterest=0
+		mortgage.OutstandingFees=0
+		mortgage.Fees=nil
+		mortgage.MortgageStartDate=""
+		mortgage.StageHistory=nil
+		mortgage.SchemaVersion=MORTGAGE_SCHEMA_VERSION
+		if mortgage.DayCountConvention == "" {
+			  mortgage.DayCountConvention = DAY_COUNT_ACT_365
+		}
+		if !day_count_conventions[mortgage.DayCountConvention] {
+			  return nil, errors.New("Invalid day count convention " + mortgage.DayCountConvention)
+		}
+
+		//Adjustable rate mortgages start at the index rate plus margin unless an initial rate is given
+		if mortgage.AdjustableRate != nil {
+			  err = t.validate_adjustable_rate(stub, &mortgage)
+			  if err != nil {
+				    return nil, err
+			  }
+		}
 
 	  mortgages.MortgageNumbers             = append(mortgages.MortgageNumbers,mortgage.MortgageNumber)
-	  mortgages.CustomerNames               = append(mortgages.CustomerNames,mortgage.CustomerName)
+	  mortgages.CustomerIDs                 = append(mortgages.CustomerIDs,mortgage.CustomerID)
 		mortgages.MortgageStages              = append(mortgages.MortgageStages,mortgage.MortgageStage)
 		mortgages.ConformedMortgages          = append(mortgages.ConformedMortgages,mortgage.ConformedMortgage)
 		mortgages.MortgagePropertyOwnerships  = append(mortgages.MortgagePropertyOwnerships,mortgage.MortgagePropertyOwnership)
 
+		err = t.record_stage(stub, &mortgage)
+		if err != nil {
+			  return nil, err
+		}
+
     // Update Mortgage data into bytes.
 		mortgagebytes, err := json.Marshal(mortgage)
 		if err != nil {
@@ -201,6 +353,21 @@ func (t *SimpleChaincode) create_mortgage_application(stub shim.ChaincodeStubInt
 
 		//Store updated Mortgage Portfolio in blockchain
 		err = stub.PutState("mortgages", bytes)
+		if err != nil {
+			  return nil, err
+		}
+
+		err = t.record_mortgage_history(stub, mortgage, true)
+		if err != nil {
+			  return nil, err
+		}
+
+		//Link the new Mortgage to its customer
+		customer.MortgageNumbers = append(customer.MortgageNumbers,mortgage.MortgageNumber)
+		err = t.save_customer(stub, customer)
+		if err != nil {
+			  return nil, err
+		}
 
     return nil, nil
 }
@@ -209,11 +376,9 @@ func (t *SimpleChaincode) modify_mortgage(stub shim.ChaincodeStubInterface, args
     // Variable declaration
 	  var mortgage Mortgage
 		var currentmortgage Mortgage
-		var mortgages mortgage_portfolio
     var err error
-		var mortgagebytes, bytes []byte
+		var mortgagebytes []byte
 		var amountDisbursed bool
-		var counter, value, Ratio_1, Ratio_2, Ratio_3, Rating_Ratio int
 
 		//Logging
     fmt.Println("running modify_mortgage()")
@@ -236,21 +401,112 @@ func (t *SimpleChaincode) modify_mortgage(stub shim.ChaincodeStubInterface, args
  			  return nil, errors.New("error while fetching mortgage number")
  		}
 
-		err = json.Unmarshal(mortgagebytes,&currentmortgage)
+		currentmortgage, err = upgrade_mortgage(mortgagebytes)
 		if err != nil {
  			  return nil, errors.New("error while Unmarshalling mortgages for current mortgage number")
  		}
 
+		username, err := t.check_mortgage_modifier(stub, currentmortgage)
+		if err != nil {
+			  return nil, err
+		}
+
 		//Update current Mortgage Fields
+		customerID := currentmortgage.CustomerID
+		brokerID := currentmortgage.BrokerID
+		propertyID := currentmortgage.PropertyID
+		valuation := currentmortgage.PropertyValuation
+		appraisalIDs := currentmortgage.AppraisalIDs
+		acceptedAppraisalID := currentmortgage.AcceptedAppraisalID
+		documentIDs := currentmortgage.DocumentIDs
+		adjustableRate := currentmortgage.AdjustableRate
+		payments := currentmortgage.Payments
+		stage := currentmortgage.MortgageStage
+		approvedMaxAmount := currentmortgage.ApprovedMaxAmount
+		rateofInterest := currentmortgage.RateofInterest
+		duration := currentmortgage.MortgageDuration
+		deferredPrincipal := currentmortgage.DeferredPrincipal
+		termsVersion := currentmortgage.TermsVersion
+		modifications := currentmortgage.Modifications
+		dayCountConvention := currentmortgage.DayCountConvention
+		interestAccruedTo := currentmortgage.InterestAccruedTo
+		accruedInterest := currentmortgage.AccruedInterest
+		outstandingFees := currentmortgage.OutstandingFees
+		fees := currentmortgage.Fees
+		startDate := currentmortgage.MortgageStartDate
+		stageHistory := currentmortgage.StageHistory
+		remainingAmount := currentmortgage.RemainingMortgageAmount
+		grantedLoanAmount := currentmortgage.GrantedLoanAmount
+		ownershipcost := currentmortgage.Ownershipcost
 		err = json.Unmarshal([]byte(mortgage_json), &currentmortgage)
     if err != nil {
 			  return nil, errors.New("error while Unmarshalling mortgage json object")
 		}
 
+		//Customer and property links can not be changed once the mortgage is created
+		currentmortgage.CustomerID = customerID
+		currentmortgage.BrokerID = brokerID
+		currentmortgage.PropertyID = propertyID
+
+		//Property valuation is only taken from the latest accepted appraisal
+		currentmortgage.PropertyValuation = valuation
+		currentmortgage.AppraisalIDs = appraisalIDs
+		currentmortgage.AcceptedAppraisalID = acceptedAppraisalID
+
+		//Documents are only added through attach_document
+		currentmortgage.DocumentIDs = documentIDs
+
+		//Adjustable rate terms are fixed at creation and their rate only changes through reset_rates
+		currentmortgage.AdjustableRate = adjustableRate
+		currentmortgage.Payments = payments
+
+		//Stages up to approval are only set by the underwriting decision and a disbursed mortgage stays disbursed
+		currentmortgage.ApprovedMaxAmount = approvedMaxAmount
+		if !strings.Contains(strings.ToUpper(stage), "DISBURSED:") || !strings.Contains(strings.ToUpper(currentmortgage.MortgageStage), "DISBURSED:") {
+			currentmortgage.MortgageStage = stage
+		}
+		if adjustableRate != nil {
+			currentmortgage.RateofInterest = rateofInterest
+		}
+
+		//Terms of a disbursed mortgage only change through modify_loan and grant_forbearance
+		currentmortgage.DeferredPrincipal = deferredPrincipal
+		currentmortgage.TermsVersion = termsVersion
+		currentmortgage.Modifications = modifications
+
+		//Interest and fees only change through accrue_interest, assess_fee and payments
+		currentmortgage.DayCountConvention = dayCountConvention
+		currentmortgage.InterestAccruedTo = interestAccruedTo
+		currentmortgage.AccruedInterest = accruedInterest
+		currentmortgage.OutstandingFees = outstandingFees
+		currentmortgage.Fees = fees
+
+		//The amounts of a disbursed mortgage are fixed at disbursement and its balance only changes through payments,
+		//accrue_interest and assess_fee
+		if strings.Contains(strings.ToUpper(stage), "DISBURSED:") {
+			currentmortgage.RemainingMortgageAmount = remainingAmount
+			currentmortgage.GrantedLoanAmount = grantedLoanAmount
+			currentmortgage.Ownershipcost = ownershipcost
+		}
+		currentmortgage.ModifiedBy = username
+
+		//The start date and stage history are taken from transaction timestamps
+		currentmortgage.MortgageStartDate = startDate
+		currentmortgage.StageHistory = stageHistory
+
+		//Once underwriting has decided, the rate and duration only change through approve_mortgage and modify_loan
+		if underwriting_decided(stage) {
+			currentmortgage.RateofInterest = rateofInterest
+			currentmortgage.MortgageDuration = duration
+		}
+
     // smart contract fields
 		// Update Mortgage Stage and update Mortgage Property Ownership
 		if strings.ToUpper(currentmortgage.MortgageStage)== "APPROVED:" && currentmortgage.Ownershipcost > 0 {
 			 currentmortgage.GrantedLoanAmount = currentmortgage.Ownershipcost
+			 if currentmortgage.ApprovedMaxAmount > 0 && currentmortgage.GrantedLoanAmount > currentmortgage.ApprovedMaxAmount {
+				  currentmortgage.GrantedLoanAmount = currentmortgage.ApprovedMaxAmount
+			 }
 			 currentmortgage.MortgageStage="Disbursed:"
 			 amountDisbursed=true
 		}else{
@@ -278,10 +534,17 @@ func (t *SimpleChaincode) modify_mortgage(stub shim.ChaincodeStubInterface, args
 				 }
 		} else if amountDisbursed {
 			  currentmortgage.RemainingMortgageAmount = currentmortgage.GrantedLoanAmount
-		} else if (currentmortgage.RemainingMortgageAmount - currentmortgage.LastPaymentAmount) > 0 {
-		    currentmortgage.RemainingMortgageAmount = currentmortgage.RemainingMortgageAmount - currentmortgage.LastPaymentAmount
-		} else {
-			  currentmortgage.RemainingMortgageAmount=0
+			  disbursed, err := t.get_tx_time(stub)
+			  if err != nil {
+				    return nil, err
+			  }
+			  currentmortgage.InterestAccruedTo = disbursed.Format(time.RFC3339)
+			  currentmortgage.MortgageStartDate = disbursed.Format(time.RFC3339)
+		} else if mortgage.LastPaymentAmount > 0 {
+			  err = t.apply_payment(stub, &currentmortgage, mortgage.LastPaymentAmount)
+			  if err != nil {
+				    return nil, err
+			  }
 		}
 
     // if customer pays out property is moved back to customer.
@@ -289,7 +552,152 @@ func (t *SimpleChaincode) modify_mortgage(stub shim.ChaincodeStubInterface, args
 			 currentmortgage.MortgagePropertyOwnership="CUSTOMER"
 		}
 
-		// Calculate Risk Classification.
+		// Calculate Risk Classification, Risk Adjusted Return, Expected Annual CashFlow and conformance.
+		err = t.update_risk_profile(stub, &currentmortgage)
+		if err != nil {
+			 return nil, err
+		}
+
+		//Accrue the commission of the originating broker on disbursement
+		if amountDisbursed && currentmortgage.BrokerID != "" {
+			 err = t.accrue_commission(stub, currentmortgage)
+			 if err != nil {
+				  return nil, err
+			 }
+		}
+
+		//Record, reassign or release the lien on the property
+		err = t.update_property_lien(stub, currentmortgage)
+		if err != nil {
+			 return nil, err
+		}
+
+		//Store updated Mortgage and Mortgage Portfolio in blockchain
+		err = t.save_mortgage(stub, currentmortgage)
+		if err != nil {
+			 return nil, err
+		}
+
+    return nil, nil
+}
+
+//==============================================================================================================================
+//	check_mortgage_modifier - The lending bank can modify any mortgage, the customer and broker of a mortgage only until
+//			  underwriting has decided and the GSE and partner bank only to buy a mortgage offered to them. Returns
+//			  the username of the caller.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_mortgage_modifier(stub shim.ChaincodeStubInterface, mortgage Mortgage) (string, error) {
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return "", err
+	}
+
+	switch role {
+	case LENDING_BANK:
+		return username, nil
+	case CUSTOMER, BROKER:
+		party, err := t.is_mortgage_party(stub, mortgage, username, role)
+		if err != nil {
+			return "", err
+		}
+		if party && !underwriting_decided(mortgage.MortgageStage) {
+			return username, nil
+		}
+	case GSE:
+		if strings.ToUpper(mortgage.MortgageStage) == "DISBURSED:READY TO PURCHASE" {
+			return username, nil
+		}
+	case PARTNER_BANK:
+		if strings.ToUpper(mortgage.MortgageStage) == "DISBURSED:REQUEST TO PURCHASE" {
+			return username, nil
+		}
+	}
+	return "", errors.New("Permission denied. Mortgage " + strconv.Itoa(mortgage.MortgageNumber) + " can not be modified by " + role)
+}
+
+//==============================================================================================================================
+//	apply_payment - Applies a payment received on a disbursed mortgage to its remaining amount and records it. Interest
+//			  is accrued up to the payment first, the escrow contribution of the mortgage is taken out of the
+//			  payment and the rest goes to fees, then interest, then principal.
+//			  A payment of more than is due is refused.
+//==============================================================================================================================
+func (t *SimpleChaincode) apply_payment(stub shim.ChaincodeStubInterface, mortgage *Mortgage, amount int) error {
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+
+	err = accrue_mortgage_interest(mortgage, now)
+	if err != nil {
+		return err
+	}
+
+	escrow, err := t.collect_escrow(stub, mortgage.MortgageNumber, amount)
+	if err != nil {
+		return err
+	}
+	available := amount - escrow
+	balance := principal_balance(*mortgage)
+
+	fees := available
+	if fees > mortgage.OutstandingFees {
+		fees = mortgage.OutstandingFees
+	}
+	mortgage.OutstandingFees -= fees
+	available -= fees
+
+	interest := available
+	if interest > mortgage.AccruedInterest {
+		interest = mortgage.AccruedInterest
+	}
+	mortgage.AccruedInterest -= interest
+	available -= interest
+
+	// The whole payment must be applied for the recorded payments to add up to the balance.
+	principal := available
+	if principal > balance {
+		due := amount - available + balance
+		return errors.New("Payment of " + strconv.Itoa(amount) + " is more than the " + strconv.Itoa(due) + " due on mortgage " + strconv.Itoa(mortgage.MortgageNumber))
+	}
+	mortgage.RemainingMortgageAmount -= fees + interest + principal
+
+	// Deferred principal is only paid down once the amortizing balance is paid off.
+	if mortgage.DeferredPrincipal > principal_balance(*mortgage) {
+		mortgage.DeferredPrincipal = principal_balance(*mortgage)
+	}
+
+	mortgage.Payments = append(mortgage.Payments, PaymentRecord{
+		Amount:      amount,
+		Principal:   principal,
+		Interest:    interest,
+		Fees:        fees,
+		Escrow:      escrow,
+		TxID:        stub.GetTxID(),
+		PaymentDate: now.Format(time.RFC3339),
+	})
+	return nil
+}
+
+//==============================================================================================================================
+//	update_risk_profile - Takes the credit details of the mortgage from the freshest credit report of the customer and
+//			  recalculates the risk of the mortgage.
+//==============================================================================================================================
+func (t *SimpleChaincode) update_risk_profile(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	err := t.apply_credit_report(stub, mortgage)
+	if err != nil {
+		return err
+	}
+	t.calculate_risk(mortgage)
+	return nil
+}
+
+//==============================================================================================================================
+//	calculate_risk - Derives the risk classification, risk adjusted return, expected annual cashflow and conformance
+//			  of a mortgage from its current amounts, valuation and credit details.
+//==============================================================================================================================
+func (t *SimpleChaincode) calculate_risk(currentmortgage *Mortgage) {
+		var Ratio_1, Ratio_2, Ratio_3, Rating_Ratio int
+
 			if currentmortgage.RemainingMortgageAmount > 0 && currentmortgage.FinancialWorth > 0 && currentmortgage.CreditScore > 0 && currentmortgage.PropertyValuation > 0 {
 			   switch {
 			   case  currentmortgage.PropertyValuation*100/currentmortgage.RemainingMortgageAmount > 75:
@@ -347,64 +755,19 @@ func (t *SimpleChaincode) modify_mortgage(stub shim.ChaincodeStubInterface, args
 			    default :
 			         currentmortgage.RiskAdjustedReturn=0
 			}
-			// Calculate Expected Annual CashFlow.
+			// Calculate Expected Annual CashFlow, principal repaid over the year plus interest at the current rate.
 			if currentmortgage.MortgageDuration > 365 {
 			   currentmortgage.ExpectedAnnualCashflow=currentmortgage.RemainingMortgageAmount/currentmortgage.MortgageDuration*365
 			}else {
 			   currentmortgage.ExpectedAnnualCashflow=currentmortgage.RemainingMortgageAmount
 			}
+			currentmortgage.ExpectedAnnualCashflow+=int(float32(currentmortgage.RemainingMortgageAmount)*currentmortgage.RateofInterest/100)
 			// Calculate if conformed currentmortgage.
 			if (currentmortgage.RiskClassification=="A" || currentmortgage.RiskClassification=="B" || currentmortgage.RiskClassification=="C") && currentmortgage.RemainingMortgageAmount <= 424100  && strings.Contains(strings.ToUpper(currentmortgage.MortgageStage),strings.ToUpper("Disbursed:"))  {
 			   currentmortgage.ConformedMortgage=true
 			}else{
 			   currentmortgage.ConformedMortgage=false
 			}
-
-			//Get latest mortgages porfolio in blockchain and assign it to variable array
-	 	 bytes, err = stub.GetState("mortgages")
-	 	 if err != nil {
-	 			 return nil, errors.New("error while retrieving mortgage portfolio json object")
-	 	 }
-	 	 err = json.Unmarshal(bytes,&mortgages)
-	 	 if err != nil {
-	 			 return nil, errors.New("error while Unmarshalling mortgages for new mortgage number")
-	 	 }
-
-
-    // identify place to update mortgage portfolio
-		 for counter, value = range mortgages.MortgageNumbers {
-				 if value == currentmortgage.MortgageNumber {
-						break
-			 }
-		 }
-
-     // Update mortgage portfolio with new details
-		 mortgages.CustomerNames[counter]               = currentmortgage.CustomerName
-		 mortgages.MortgageStages[counter]              = currentmortgage.MortgageStage
-     mortgages.ConformedMortgages[counter]          = currentmortgage.ConformedMortgage
-		 mortgages.MortgagePropertyOwnerships[counter]  = currentmortgage.MortgagePropertyOwnership
-
-		 //package updated Mortgage Portfolio data into bytes.
-	 		bytes, err = json.Marshal(mortgages)
-	 		if err != nil {
-	 			 return nil, errors.New("Add to Mortgage Portfolio record")
-	 		}
-
-		//Store updated Mortgage data in blockchain
-		mortgagebytes, err = json.Marshal(currentmortgage)
-		if err != nil {
-			 return nil, errors.New("Error in Marshalling New Mortgage record")
-		 }
-
-		err = stub.PutState(string(currentmortgage.MortgageNumber), mortgagebytes)
-    if err != nil {
-        return nil, err
-    }
-
-		//Store updated Mortgage Portfolio in blockchain
-		err = stub.PutState("mortgages", bytes)
-
-    return nil, nil
 }
 
 
@@ -419,6 +782,44 @@ func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function strin
 			     return t.retrieve_mortgage(stub, args)
 	}else if function == "retrieve_mortgages" {
 			     return t.retrieve_mortgages(stub, args)
+	}else if function == "retrieve_customer" {
+			     return t.retrieve_customer(stub, args)
+	}else if function == "retrieve_customer_mortgages" {
+			     return t.retrieve_customer_mortgages(stub, args)
+	}else if function == "retrieve_property" {
+			     return t.retrieve_property(stub, args)
+	}else if function == "retrieve_appraisals" {
+			     return t.retrieve_appraisals(stub, args)
+	}else if function == "retrieve_credit_reports" {
+			     return t.retrieve_credit_reports(stub, args)
+	}else if function == "retrieve_rate_index" {
+			     return t.retrieve_rate_index(stub, args)
+	}else if function == "retrieve_broker_statement" {
+			     return t.retrieve_broker_statement(stub, args)
+	}else if function == "audit_portfolio_report" {
+			     return t.audit_portfolio_report(stub, args)
+	}else if function == "audit_changed_mortgages" {
+			     return t.audit_changed_mortgages(stub, args)
+	}else if function == "audit_exceptions" {
+			     return t.audit_exceptions(stub, args)
+	}else if function == "portfolio_analytics" {
+			     return t.portfolio_analytics(stub, args)
+	}else if function == "retrieve_underwriting" {
+			     return t.retrieve_underwriting(stub, args)
+	}else if function == "retrieve_adverse_action" {
+			     return t.retrieve_adverse_action(stub, args)
+	}else if function == "retrieve_documents" {
+			     return t.retrieve_documents(stub, args)
+	}else if function == "verify_document" {
+			     return t.verify_document(stub, args)
+	}else if function == "retrieve_escrow" {
+			     return t.retrieve_escrow(stub, args)
+	}else if function == "escrow_analysis" {
+			     return t.escrow_analysis(stub, args)
+	}else if function == "retrieve_loan_status" {
+			     return t.retrieve_loan_status(stub, args)
+	}else if function == "retrieve_migration_status" {
+			     return t.retrieve_migration_status(stub, args)
 	}
 
 	fmt.Println("query did not find func: " + function)						//error
@@ -431,12 +832,12 @@ func (t *SimpleChaincode) retrieve_mortgage_portfolio(stub shim.ChaincodeStubInt
     var err error
 
     //retrieve Mortgage Portfolio
-    valAsbytes, err := stub.GetState("mortgages")
+    mortgages, err := t.get_mortgage_portfolio(stub)
     if err != nil {
         jsonResp = "{\"Error\":\"Failed to retrieve mortgage portfolio\"}"
         return nil, errors.New(jsonResp)
     }
-    return valAsbytes, nil
+    return json.Marshal(mortgages)
 }
 
 func (t *SimpleChaincode) retrieve_mortgage(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
@@ -466,7 +867,16 @@ func (t *SimpleChaincode) retrieve_mortgage(stub shim.ChaincodeStubInterface, ar
 	if err != nil {
 			return nil, errors.New("error while fetching mortgage number")
 	}
-    return mortgagebytes, nil
+	if mortgagebytes == nil {
+			return nil, nil
+	}
+
+	//Return the mortgage upgraded to the current schema version
+	mortgage, err = upgrade_mortgage(mortgagebytes)
+	if err != nil {
+			return nil, errors.New("error while Unmarshalling mortgage number")
+	}
+    return json.Marshal(mortgage)
 }
 
 func (t *SimpleChaincode) retrieve_mortgages(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
@@ -478,15 +888,11 @@ func (t *SimpleChaincode) retrieve_mortgages(stub shim.ChaincodeStubInterface, a
 		var value int
 		var mortgagebytes []byte
     //retrieve Mortgage Portfolio
-    valAsbytes, err := stub.GetState("mortgages")
+    mortgages, err = t.get_mortgage_portfolio(stub)
     if err != nil {
         jsonResp = "{\"Error\":\"Failed to retrieve mortgage portfolio\"}"
         return nil, errors.New(jsonResp)
     }
-		err = json.Unmarshal(valAsbytes,&mortgages)
-		if err != nil {
-				return nil, errors.New("error while Unmarshalling mortgages")
-		}
 
 	 // identify place to update mortgage portfolio
 		for _ , value = range mortgages.MortgageNumbers {
@@ -496,7 +902,7 @@ func (t *SimpleChaincode) retrieve_mortgages(stub shim.ChaincodeStubInterface, a
 		 			  return nil, errors.New("error while fetching mortgage number")
 		 		}
 
-				err = json.Unmarshal(mortgagebytes,&mortgage)
+				mortgage, err = upgrade_mortgage(mortgagebytes)
 				if err != nil {
 		 			  return nil, errors.New("error while Unmarshalling mortgages for mortgage number")
 		 		}else {
@@ -509,3 +915,199 @@ func (t *SimpleChaincode) retrieve_mortgages(stub shim.ChaincodeStubInterface, a
 		}
     return mortgagelist_bytes, nil
 }
+
+//==============================================================================================================================
+//	get_mortgage - Retrieves a single Mortgage record from the blockchain by its mortgage number.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_mortgage(stub shim.ChaincodeStubInterface, mortgageNumber int) (Mortgage, error) {
+	var mortgage Mortgage
+
+	mortgagebytes, err := stub.GetState(string(rune(mortgageNumber)))
+	if err != nil {
+		return mortgage, errors.New("error while fetching mortgage number " + strconv.Itoa(mortgageNumber))
+	}
+	if mortgagebytes == nil {
+		return mortgage, errors.New("mortgage number " + strconv.Itoa(mortgageNumber) + " does not exist")
+	}
+
+	mortgage, err = upgrade_mortgage(mortgagebytes)
+	if err != nil {
+		return mortgage, errors.New("error while Unmarshalling mortgage number " + strconv.Itoa(mortgageNumber))
+	}
+	return mortgage, nil
+}
+
+//==============================================================================================================================
+//	get_mortgage_portfolio - Retrieves the Mortgage Portfolio index of all mortgages from the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_mortgage_portfolio(stub shim.ChaincodeStubInterface) (mortgage_portfolio, error) {
+	var mortgages mortgage_portfolio
+
+	bytes, err := stub.GetState("mortgages")
+	if err != nil {
+		return mortgages, errors.New("error while retrieving mortgage portfolio json object")
+	}
+	err = json.Unmarshal(bytes, &mortgages)
+	if err != nil {
+		return mortgages, errors.New("error while Unmarshalling mortgage portfolio")
+	}
+
+	upgrade_portfolio(&mortgages)
+	return mortgages, nil
+}
+
+//==============================================================================================================================
+//	save_mortgage - Writes a Mortgage record to the blockchain and updates its entry in the Mortgage Portfolio.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_mortgage(stub shim.ChaincodeStubInterface, mortgage Mortgage) error {
+
+	//Get latest mortgages porfolio in blockchain and assign it to variable array
+	mortgages, err := t.get_mortgage_portfolio(stub)
+	if err != nil {
+		return err
+	}
+
+	// identify place to update mortgage portfolio
+	counter := -1
+	for i, value := range mortgages.MortgageNumbers {
+		if value == mortgage.MortgageNumber {
+			counter = i
+			break
+		}
+	}
+	if counter < 0 {
+		return errors.New("mortgage number " + strconv.Itoa(mortgage.MortgageNumber) + " is not in the mortgage portfolio")
+	}
+
+	// Update mortgage portfolio with new details
+	mortgages.CustomerIDs[counter] = mortgage.CustomerID
+	mortgages.MortgageStages[counter] = mortgage.MortgageStage
+	mortgages.ConformedMortgages[counter] = mortgage.ConformedMortgage
+	mortgages.MortgagePropertyOwnerships[counter] = mortgage.MortgagePropertyOwnership
+
+	//package updated Mortgage Portfolio data into bytes.
+	bytes, err := json.Marshal(mortgages)
+	if err != nil {
+		return errors.New("Add to Mortgage Portfolio record")
+	}
+
+	// The customer and property of a mortgage upgraded from version 1 get records of their own.
+	if mortgage.Legacy != nil {
+		err = t.link_legacy_parties(stub, mortgage)
+		if err != nil {
+			return err
+		}
+		mortgage.Legacy = nil
+	}
+
+	mortgage.SchemaVersion = MORTGAGE_SCHEMA_VERSION
+	err = t.record_stage(stub, &mortgage)
+	if err != nil {
+		return err
+	}
+
+	//Store updated Mortgage data in blockchain
+	mortgagebytes, err := json.Marshal(mortgage)
+	if err != nil {
+		return errors.New("Error in Marshalling Mortgage record")
+	}
+
+	err = stub.PutState(string(rune(mortgage.MortgageNumber)), mortgagebytes)
+	if err != nil {
+		return err
+	}
+
+	err = t.record_mortgage_history(stub, mortgage, false)
+	if err != nil {
+		return err
+	}
+
+	//Store updated Mortgage Portfolio in blockchain
+	return stub.PutState("mortgages", bytes)
+}
+
+//==============================================================================================================================
+//	get_tx_time - Returns the timestamp of the current transaction.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_tx_time(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, errors.New("error while fetching transaction timestamp")
+	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
+}
+
+//==============================================================================================================================
+//	record_stage - Records the time a mortgage entered its current stage when the stage has changed.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_stage(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	if len(mortgage.StageHistory) > 0 && mortgage.StageHistory[len(mortgage.StageHistory)-1].Stage == mortgage.MortgageStage {
+		return nil
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+	mortgage.StageHistory = append(mortgage.StageHistory, StageEntry{
+		Stage:     mortgage.MortgageStage,
+		EnteredAt: now.Format(time.RFC3339),
+		TxID:      stub.GetTxID(),
+	})
+	return nil
+}
+
+//==============================================================================================================================
+//	validate_date - Checks a date supplied by a caller is a valid date that is not after the current transaction and
+//			  returns it in its stored form, an ISO date or an RFC 3339 timestamp in UTC.
+//==============================================================================================================================
+func (t *SimpleChaincode) validate_date(stub shim.ChaincodeStubInterface, value string) (string, error) {
+	date, err := parse_date(value)
+	if err != nil {
+		return "", err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return "", err
+	}
+	if date.After(now) {
+		return "", errors.New("Date " + value + " is after the transaction date")
+	}
+
+	if len(value) == len("2006-01-02") {
+		return value, nil
+	}
+	return date.Format(time.RFC3339), nil
+}
+
+//==============================================================================================================================
+//	parse_date - Parses a date given either as an ISO date (2006-01-02) or as an RFC 3339 timestamp.
+//==============================================================================================================================
+func parse_date(value string) (time.Time, error) {
+	date, err := time.Parse("2006-01-02", value)
+	if err == nil {
+		return date, nil
+	}
+	date, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid date " + value + ". Expecting YYYY-MM-DD or RFC 3339")
+	}
+	return date.UTC(), nil
+}
+
+//==============================================================================================================================
+//	get_caller_data - Retrieves the username and role of the caller from the attributes of their certificate.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_caller_data(stub shim.ChaincodeStubInterface) (string, string, error) {
+	username, err := stub.ReadCertAttribute("username")
+	if err != nil {
+		return "", "", errors.New("Couldn't get attribute 'username'. Error: " + err.Error())
+	}
+
+	role, err := stub.ReadCertAttribute("role")
+	if err != nil {
+		return "", "", errors.New("Couldn't get attribute 'role'. Error: " + err.Error())
+	}
+	return string(username), string(role), nil
+}
@@ -0,0 +1,161 @@
+/*
+Dream Mortgage Chaincode - Portfolio Analytics
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	PortfolioAnalytics - Returned by portfolio_analytics, aggregates of the outstanding mortgages held by a holder.
+//			  Rates and returns are weighted by the outstanding principal of each mortgage.
+//==============================================================================================================================
+type PortfolioAnalytics struct {
+	Holder                     string                    `json:"Holder"`
+	MortgageCount              int                       `json:"MortgageCount"`
+	OutstandingPrincipal       int                       `json:"OutstandingPrincipal"`
+	WeightedAverageRate        float32                   `json:"WeightedAverageRate"`
+	WeightedRiskAdjustedReturn float32                   `json:"WeightedRiskAdjustedReturn"`
+	ExpectedAnnualCashflow     int                       `json:"ExpectedAnnualCashflow"`
+	ByRiskClass                map[string]PrincipalTotal `json:"ByRiskClass"`
+	ByRemainingTerm            map[string]PrincipalTotal `json:"ByRemainingTerm"`
+}
+
+//==============================================================================================================================
+//	PrincipalTotal - A bucket of PortfolioAnalytics, its principal adds up to OutstandingPrincipal over the buckets.
+//==============================================================================================================================
+type PrincipalTotal struct {
+	Count                int `json:"Count"`
+	OutstandingPrincipal int `json:"OutstandingPrincipal"`
+}
+
+//==============================================================================================================================
+//	holder_roles - The holder whose portfolio each role is allowed to analyse.
+//==============================================================================================================================
+var holder_roles = map[string]string{
+	LENDING_BANK: "LENDING_BANK",
+	GSE:          "GSE",
+	PARTNER_BANK: "PARTNER_BANK",
+}
+
+func (t *SimpleChaincode) portfolio_analytics(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var rateSum, returnSum float64
+
+	//Logging
+	fmt.Println("running portfolio_analytics()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting holder e.g. LENDING_BANK, GSE or PARTNER_BANK")
+	}
+	holder := args[0]
+
+	_, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != AUDITOR && holder_roles[role] != holder {
+		return nil, errors.New("Permission denied. Holders can only analyse their own portfolio")
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	analytics := PortfolioAnalytics{
+		Holder:          holder,
+		ByRiskClass:     map[string]PrincipalTotal{},
+		ByRemainingTerm: map[string]PrincipalTotal{},
+	}
+
+	mortgages, err := t.get_mortgage_portfolio(stub)
+	if err != nil {
+		return nil, err
+	}
+	for i, number := range mortgages.MortgageNumbers {
+		if mortgages.MortgagePropertyOwnerships[i] != holder {
+			continue
+		}
+		mortgage, err := t.get_mortgage(stub, number)
+		if err != nil {
+			return nil, err
+		}
+		if mortgage.RemainingMortgageAmount <= 0 {
+			continue
+		}
+
+		riskClass := mortgage.RiskClassification
+		if riskClass == "" {
+			riskClass = "UNCLASSIFIED"
+		}
+
+		// Accrued interest and unpaid fees are part of the remaining amount but not of the principal.
+		principal := principal_balance(mortgage)
+
+		analytics.MortgageCount++
+		analytics.OutstandingPrincipal += principal
+		analytics.ExpectedAnnualCashflow += mortgage.ExpectedAnnualCashflow
+		rateSum += float64(mortgage.RateofInterest) * float64(principal)
+		returnSum += float64(mortgage.RiskAdjustedReturn) * float64(principal)
+		add_principal_total(analytics.ByRiskClass, riskClass, principal)
+		add_principal_total(analytics.ByRemainingTerm, remaining_term_bucket(mortgage, now), principal)
+	}
+
+	if analytics.OutstandingPrincipal > 0 {
+		analytics.WeightedAverageRate = float32(rateSum / float64(analytics.OutstandingPrincipal))
+		analytics.WeightedRiskAdjustedReturn = float32(returnSum / float64(analytics.OutstandingPrincipal))
+	}
+
+	bytes, err := json.Marshal(analytics)
+	if err != nil {
+		return nil, errors.New("error while marshalling portfolio analytics")
+	}
+	return bytes, nil
+}
+
+func add_principal_total(totals map[string]PrincipalTotal, key string, principal int) {
+	total := totals[key]
+	total.Count++
+	total.OutstandingPrincipal += principal
+	totals[key] = total
+}
+
+//==============================================================================================================================
+//	remaining_term_days - Days left of the mortgage duration. Without a valid start date the full duration is left.
+//==============================================================================================================================
+func remaining_term_days(mortgage Mortgage, now time.Time) int {
+	start, err := parse_date(mortgage.MortgageStartDate)
+	if err != nil || start.After(now) {
+		return mortgage.MortgageDuration
+	}
+
+	remaining := mortgage.MortgageDuration - int(now.Sub(start).Hours()/24)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining
+}
+
+func remaining_term_bucket(mortgage Mortgage, now time.Time) string {
+	years := remaining_term_days(mortgage, now) / 365
+
+	switch {
+	case years < 1:
+		return "UNDER_1Y"
+	case years < 5:
+		return "1Y_TO_5Y"
+	case years < 10:
+		return "5Y_TO_10Y"
+	case years < 20:
+		return "10Y_TO_20Y"
+	default:
+		return "20Y_AND_OVER"
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Dream Mortgage Chaincode - Appraisals
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	APPRAISAL STATUS
+//==============================================================================================================================
+const APPRAISAL_ORDERED = "ORDERED"
+const APPRAISAL_SUBMITTED = "SUBMITTED"
+const APPRAISAL_ACCEPTED = "ACCEPTED"
+const APPRAISAL_REJECTED = "REJECTED"
+
+//==============================================================================================================================
+//	Appraisal - Defines the structure for an Appraisal object. An appraisal is ordered by the lending bank for a
+//			  mortgage, assigned to an appraiser who submits the report and then accepted or rejected by the bank.
+//==============================================================================================================================
+type Appraisal struct {
+	AppraisalID    string `json:"AppraisalID"`
+	MortgageNumber int    `json:"MortgageNumber"`
+	PropertyID     string `json:"PropertyID"`
+	Appraiser      string `json:"Appraiser"`
+	OrderedBy      string `json:"OrderedBy"`
+	Status         string `json:"Status"`
+	Value          int    `json:"Value"`
+	AppraisalDate  string `json:"AppraisalDate"`
+	DocumentHash   string `json:"DocumentHash"`
+	ReviewedBy     string `json:"ReviewedBy"`
+}
+
+func appraisal_key(appraisalID string) string {
+	return "appraisal_" + appraisalID
+}
+
+func (t *SimpleChaincode) order_appraisal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running order_appraisal()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and appraiser")
+	}
+	mortgageNumber, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[0])
+	}
+	if args[1] == "" {
+		return nil, errors.New("Appraiser is required to order an appraisal")
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != LENDING_BANK {
+		return nil, errors.New("Permission denied. Only the lending bank can order an appraisal")
+	}
+
+	mortgage, err := t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	appraisal := Appraisal{
+		AppraisalID:    args[0] + "-" + strconv.Itoa(len(mortgage.AppraisalIDs)+1),
+		MortgageNumber: mortgageNumber,
+		PropertyID:     mortgage.PropertyID,
+		Appraiser:      args[1],
+		OrderedBy:      username,
+		Status:         APPRAISAL_ORDERED,
+	}
+	err = t.save_appraisal(stub, appraisal)
+	if err != nil {
+		return nil, err
+	}
+
+	mortgage.AppraisalIDs = append(mortgage.AppraisalIDs, appraisal.AppraisalID)
+	return nil, t.save_mortgage(stub, mortgage)
+}
+
+func (t *SimpleChaincode) submit_appraisal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var report Appraisal
+
+	//Logging
+	fmt.Println("running submit_appraisal()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting one JSON appraisal report")
+	}
+	err := json.Unmarshal([]byte(args[0]), &report)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling appraisal json object")
+	}
+	if report.Value <= 0 || report.AppraisalDate == "" || report.DocumentHash == "" {
+		return nil, errors.New("Appraisal report requires Value, AppraisalDate and DocumentHash")
+	}
+	report.AppraisalDate, err = t.validate_date(stub, report.AppraisalDate)
+	if err != nil {
+		return nil, err
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	appraisal, err := t.get_appraisal(stub, report.AppraisalID)
+	if err != nil {
+		return nil, err
+	}
+	if role != APPRAISER || username != appraisal.Appraiser {
+		return nil, errors.New("Permission denied. Only the assigned appraiser can submit this appraisal")
+	}
+	if appraisal.Status != APPRAISAL_ORDERED {
+		return nil, errors.New("Appraisal " + appraisal.AppraisalID + " is " + appraisal.Status + " and can not be submitted")
+	}
+
+	appraisal.Value = report.Value
+	appraisal.AppraisalDate = report.AppraisalDate
+	appraisal.DocumentHash = report.DocumentHash
+	appraisal.Status = APPRAISAL_SUBMITTED
+
+	return nil, t.save_appraisal(stub, appraisal)
+}
+
+func (t *SimpleChaincode) review_appraisal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running review_appraisal()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting appraisal id and ACCEPTED or REJECTED")
+	}
+	if args[1] != APPRAISAL_ACCEPTED && args[1] != APPRAISAL_REJECTED {
+		return nil, errors.New("Invalid appraisal decision " + args[1])
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != LENDING_BANK {
+		return nil, errors.New("Permission denied. Only the lending bank can review an appraisal")
+	}
+
+	appraisal, err := t.get_appraisal(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if appraisal.Status != APPRAISAL_SUBMITTED {
+		return nil, errors.New("Appraisal " + appraisal.AppraisalID + " is " + appraisal.Status + " and can not be reviewed")
+	}
+	appraisal.Status = args[1]
+	appraisal.ReviewedBy = username
+
+	err = t.save_appraisal(stub, appraisal)
+	if err != nil || appraisal.Status == APPRAISAL_REJECTED {
+		return nil, err
+	}
+
+	mortgage, err := t.get_mortgage(stub, appraisal.MortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// An appraisal accepted late does not replace the valuation of a more recent accepted appraisal.
+	if mortgage.AcceptedAppraisalID != "" {
+		current, err := t.get_appraisal(stub, mortgage.AcceptedAppraisalID)
+		if err != nil {
+			return nil, err
+		}
+		currentDate, _ := parse_date(current.AppraisalDate)
+		appraisalDate, _ := parse_date(appraisal.AppraisalDate)
+		if currentDate.After(appraisalDate) {
+			return nil, nil
+		}
+	}
+
+	mortgage.AcceptedAppraisalID = appraisal.AppraisalID
+	mortgage.PropertyValuation = appraisal.Value
+	err = t.update_risk_profile(stub, &mortgage)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.save_mortgage(stub, mortgage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the valuation history of the property in line with accepted appraisals.
+	if mortgage.PropertyID == "" {
+		return nil, nil
+	}
+	property, err := t.get_mortgage_property(stub, mortgage)
+	if err != nil {
+		return nil, err
+	}
+	property.Valuations = append(property.Valuations, Valuation{
+		Value:      appraisal.Value,
+		Date:       appraisal.AppraisalDate,
+		Source:     "APPRAISAL:" + appraisal.AppraisalID,
+		RecordedBy: username,
+	})
+	return nil, t.save_property(stub, property)
+}
+
+func (t *SimpleChaincode) retrieve_appraisals(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var appraisals []Appraisal
+
+	//Logging
+	fmt.Println("running retrieve_appraisals()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number")
+	}
+	mortgageNumber, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[0])
+	}
+
+	mortgage, err := t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, appraisalID := range mortgage.AppraisalIDs {
+		appraisal, err := t.get_appraisal(stub, appraisalID)
+		if err != nil {
+			return nil, err
+		}
+		appraisals = append(appraisals, appraisal)
+	}
+
+	bytes, err := json.Marshal(appraisals)
+	if err != nil {
+		return nil, errors.New("error while marshalling the appraisal list")
+	}
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	get_appraisal - Retrieves an Appraisal record from the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_appraisal(stub shim.ChaincodeStubInterface, appraisalID string) (Appraisal, error) {
+	var appraisal Appraisal
+
+	bytes, err := stub.GetState(appraisal_key(appraisalID))
+	if err != nil {
+		return appraisal, errors.New("error while fetching appraisal " + appraisalID)
+	}
+	if bytes == nil {
+		return appraisal, errors.New("appraisal " + appraisalID + " does not exist")
+	}
+
+	err = json.Unmarshal(bytes, &appraisal)
+	if err != nil {
+		return appraisal, errors.New("error while Unmarshalling appraisal " + appraisalID)
+	}
+	return appraisal, nil
+}
+
+//==============================================================================================================================
+//	save_appraisal - Writes an Appraisal record to the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_appraisal(stub shim.ChaincodeStubInterface, appraisal Appraisal) error {
+	bytes, err := json.Marshal(appraisal)
+	if err != nil {
+		return errors.New("Error in Marshalling Appraisal record")
+	}
+
+	err = stub.PutState(appraisal_key(appraisal.AppraisalID), bytes)
+	if err != nil {
+		return errors.New("Error storing Appraisal record in blockchain")
+	}
+	return nil
+}
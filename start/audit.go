@@ -0,0 +1,434 @@
+/*
+Dream Mortgage Chaincode - Mortgage History and Auditor Reports
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	AUDIT EXCEPTIONS
+//==============================================================================================================================
+const CONFORMANCE_MISMATCH = "CONFORMANCE_MISMATCH"
+const BALANCE_MISMATCH = "BALANCE_MISMATCH"
+
+//==============================================================================================================================
+//	MortgageVersion - A copy of a Mortgage as stored by a transaction. Every write of a mortgage adds a version to
+//			  its history so reports can be produced as of a point in time.
+//==============================================================================================================================
+type MortgageVersion struct {
+	TxID      string   `json:"TxID"`
+	Timestamp string   `json:"Timestamp"`
+	Mortgage  Mortgage `json:"Mortgage"`
+}
+
+//==============================================================================================================================
+//	MortgageHistory - The index of the history of a mortgage. Each version is stored under its own key so storing a
+//			  version does not rewrite the versions before it. CreatedAt is only known for mortgages created since
+//			  their history is kept, mortgages stored before get a history when they are next saved.
+//==============================================================================================================================
+type MortgageHistory struct {
+	Versions  int    `json:"Versions"`
+	LastTxID  string `json:"LastTxID"`
+	CreatedAt string `json:"CreatedAt,omitempty"`
+}
+
+//==============================================================================================================================
+//	PortfolioReport - Returned by audit_portfolio_report, totals of the portfolio as of a point in time.
+//==============================================================================================================================
+type PortfolioReport struct {
+	AsOf                 string                 `json:"AsOf"`
+	MortgageCount        int                    `json:"MortgageCount"`
+	TotalRemainingAmount int                    `json:"TotalRemainingAmount"`
+	ByStage              map[string]ReportTotal `json:"ByStage"`
+	ByOwnership          map[string]ReportTotal `json:"ByOwnership"`
+	ByRiskClass          map[string]ReportTotal `json:"ByRiskClass"`
+	ByConformance        map[string]ReportTotal `json:"ByConformance"`
+	WithoutHistory       []int                  `json:"WithoutHistory"`
+}
+
+type ReportTotal struct {
+	Count           int `json:"Count"`
+	RemainingAmount int `json:"RemainingAmount"`
+}
+
+//==============================================================================================================================
+//	ChangedMortgage - Returned by audit_changed_mortgages, the transactions that changed a mortgage in a window.
+//==============================================================================================================================
+type ChangedMortgage struct {
+	MortgageNumber int      `json:"MortgageNumber"`
+	Changes        int      `json:"Changes"`
+	TxIDs          []string `json:"TxIDs"`
+	LastChanged    string   `json:"LastChanged"`
+}
+
+//==============================================================================================================================
+//	AuditException - Returned by audit_exceptions, a mortgage whose stored data does not hold up to the current rules.
+//==============================================================================================================================
+type AuditException struct {
+	MortgageNumber int    `json:"MortgageNumber"`
+	Exception      string `json:"Exception"`
+	Details        string `json:"Details"`
+}
+
+func mortgage_history_key(mortgageNumber int) string {
+	return "mortgage_history_" + strconv.Itoa(mortgageNumber)
+}
+
+func mortgage_version_key(mortgageNumber int, sequence int) string {
+	return mortgage_history_key(mortgageNumber) + "_" + strconv.Itoa(sequence)
+}
+
+func (t *SimpleChaincode) audit_portfolio_report(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var asOf time.Time
+	var err error
+
+	//Logging
+	fmt.Println("running audit_portfolio_report()")
+
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting an optional as of date")
+	}
+	err = t.check_auditor(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 1 {
+		asOf, err = parse_report_time(args[0], true)
+	} else {
+		asOf, err = t.get_tx_time(stub)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := PortfolioReport{
+		AsOf:          asOf.Format(time.RFC3339),
+		ByStage:       map[string]ReportTotal{},
+		ByOwnership:   map[string]ReportTotal{},
+		ByRiskClass:   map[string]ReportTotal{},
+		ByConformance: map[string]ReportTotal{},
+	}
+
+	mortgages, err := t.get_mortgage_portfolio(stub)
+	if err != nil {
+		return nil, err
+	}
+	for _, number := range mortgages.MortgageNumbers {
+		history, err := t.get_mortgage_history(stub, number)
+		if err != nil {
+			return nil, err
+		}
+
+		// Take the last version stored at or before the as of time.
+		found := false
+		var mortgage Mortgage
+		for _, version := range history {
+			stored, err := time.Parse(time.RFC3339, version.Timestamp)
+			if err != nil || stored.After(asOf) {
+				break
+			}
+			mortgage = version.Mortgage
+			found = true
+		}
+		if !found {
+			// A mortgage is only left out when its history shows it was created after the as of time, mortgages
+			// stored before their history was kept may have existed and are listed as having no history.
+			index, err := t.get_mortgage_history_index(stub, number)
+			if err != nil {
+				return nil, err
+			}
+			created, err := time.Parse(time.RFC3339, index.CreatedAt)
+			if err == nil && created.After(asOf) {
+				continue
+			}
+			report.WithoutHistory = append(report.WithoutHistory, number)
+			continue
+		}
+
+		riskClass := mortgage.RiskClassification
+		if riskClass == "" {
+			riskClass = "UNCLASSIFIED"
+		}
+		conformance := "NON_CONFORMING"
+		if mortgage.ConformedMortgage {
+			conformance = "CONFORMING"
+		}
+
+		report.MortgageCount++
+		report.TotalRemainingAmount += mortgage.RemainingMortgageAmount
+		add_report_total(report.ByStage, mortgage.MortgageStage, mortgage)
+		add_report_total(report.ByOwnership, mortgage.MortgagePropertyOwnership, mortgage)
+		add_report_total(report.ByRiskClass, riskClass, mortgage)
+		add_report_total(report.ByConformance, conformance, mortgage)
+	}
+
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		return nil, errors.New("error while marshalling portfolio report")
+	}
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) audit_changed_mortgages(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var changed []ChangedMortgage
+
+	//Logging
+	fmt.Println("running audit_changed_mortgages()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from and to dates")
+	}
+	err := t.check_auditor(stub)
+	if err != nil {
+		return nil, err
+	}
+	from, err := parse_report_time(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parse_report_time(args[1], true)
+	if err != nil {
+		return nil, err
+	}
+
+	mortgages, err := t.get_mortgage_portfolio(stub)
+	if err != nil {
+		return nil, err
+	}
+	for _, number := range mortgages.MortgageNumbers {
+		history, err := t.get_mortgage_history(stub, number)
+		if err != nil {
+			return nil, err
+		}
+
+		change := ChangedMortgage{MortgageNumber: number}
+		for _, version := range history {
+			stored, err := time.Parse(time.RFC3339, version.Timestamp)
+			if err != nil || stored.Before(from) || stored.After(to) {
+				continue
+			}
+			change.Changes++
+			change.TxIDs = append(change.TxIDs, version.TxID)
+			change.LastChanged = version.Timestamp
+		}
+		if change.Changes > 0 {
+			changed = append(changed, change)
+		}
+	}
+
+	bytes, err := json.Marshal(changed)
+	if err != nil {
+		return nil, errors.New("error while marshalling changed mortgages")
+	}
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) audit_exceptions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var exceptions []AuditException
+
+	//Logging
+	fmt.Println("running audit_exceptions()")
+
+	err := t.check_auditor(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	mortgages, err := t.get_mortgage_portfolio(stub)
+	if err != nil {
+		return nil, err
+	}
+	for _, number := range mortgages.MortgageNumbers {
+		mortgage, err := t.get_mortgage(stub, number)
+		if err != nil {
+			return nil, err
+		}
+
+		// Conforming flag against the rules applied to the stored risk inputs today.
+		recalculated := mortgage
+		t.calculate_risk(&recalculated)
+		if recalculated.ConformedMortgage != mortgage.ConformedMortgage {
+			exceptions = append(exceptions, AuditException{
+				MortgageNumber: number,
+				Exception:      CONFORMANCE_MISMATCH,
+				Details:        "stored " + strconv.FormatBool(mortgage.ConformedMortgage) + ", current rules give " + strconv.FormatBool(recalculated.ConformedMortgage),
+			})
+		}
+
+		// Remaining amount of a disbursed mortgage against the granted amount less recorded payments.
+		if !strings.Contains(strings.ToUpper(mortgage.MortgageStage), "DISBURSED:") {
+			continue
+		}
+		expected := expected_remaining_amount(mortgage)
+		if expected != mortgage.RemainingMortgageAmount {
+			exceptions = append(exceptions, AuditException{
+				MortgageNumber: number,
+				Exception:      BALANCE_MISMATCH,
+				Details:        "remaining amount " + strconv.Itoa(mortgage.RemainingMortgageAmount) + ", recorded payments give " + strconv.Itoa(expected),
+			})
+		}
+	}
+
+	bytes, err := json.Marshal(exceptions)
+	if err != nil {
+		return nil, errors.New("error while marshalling audit exceptions")
+	}
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	expected_remaining_amount - The remaining amount of a disbursed mortgage according to its recorded payments and its
+//			  unpaid interest and fees.
+//==============================================================================================================================
+func expected_remaining_amount(mortgage Mortgage) int {
+	expected := mortgage.GrantedLoanAmount + mortgage.AccruedInterest + mortgage.OutstandingFees
+	for _, payment := range mortgage.Payments {
+		expected -= payment.Principal
+	}
+	if expected < mortgage.AccruedInterest+mortgage.OutstandingFees {
+		expected = mortgage.AccruedInterest + mortgage.OutstandingFees
+	}
+	return expected
+}
+
+func add_report_total(totals map[string]ReportTotal, key string, mortgage Mortgage) {
+	total := totals[key]
+	total.Count++
+	total.RemainingAmount += mortgage.RemainingMortgageAmount
+	totals[key] = total
+}
+
+//==============================================================================================================================
+//	parse_report_time - Parses a report boundary. A plain date as the end of a window covers the whole day.
+//==============================================================================================================================
+func parse_report_time(value string, endOfDay bool) (time.Time, error) {
+	boundary, err := parse_date(value)
+	if err != nil {
+		return boundary, err
+	}
+	if endOfDay && len(value) == len("2006-01-02") {
+		boundary = boundary.Add(24*time.Hour - time.Nanosecond)
+	}
+	return boundary, nil
+}
+
+//==============================================================================================================================
+//	check_auditor - Auditor reports are only available to the auditor.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_auditor(stub shim.ChaincodeStubInterface) error {
+	_, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return err
+	}
+	if role != AUDITOR {
+		return errors.New("Permission denied. Only the auditor can run audit reports")
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	record_mortgage_history - Adds the mortgage as stored by the current transaction to its history, created is set by
+//			  the transaction that creates the mortgage.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_mortgage_history(stub shim.ChaincodeStubInterface, mortgage Mortgage, created bool) error {
+	index, err := t.get_mortgage_history_index(stub, mortgage.MortgageNumber)
+	if err != nil {
+		return err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+
+	// A transaction storing the mortgage more than once only keeps its last version.
+	if index.Versions == 0 || index.LastTxID != stub.GetTxID() {
+		index.Versions++
+		index.LastTxID = stub.GetTxID()
+	}
+	if created {
+		index.CreatedAt = now.Format(time.RFC3339)
+	}
+
+	bytes, err := json.Marshal(MortgageVersion{
+		TxID:      stub.GetTxID(),
+		Timestamp: now.Format(time.RFC3339),
+		Mortgage:  mortgage,
+	})
+	if err != nil {
+		return errors.New("Error in Marshalling Mortgage History record")
+	}
+	err = stub.PutState(mortgage_version_key(mortgage.MortgageNumber, index.Versions-1), bytes)
+	if err != nil {
+		return errors.New("Error storing Mortgage History record in blockchain")
+	}
+
+	bytes, err = json.Marshal(index)
+	if err != nil {
+		return errors.New("Error in Marshalling Mortgage History record")
+	}
+	err = stub.PutState(mortgage_history_key(mortgage.MortgageNumber), bytes)
+	if err != nil {
+		return errors.New("Error storing Mortgage History record in blockchain")
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	get_mortgage_history - Retrieves the stored versions of a mortgage, oldest first.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_mortgage_history(stub shim.ChaincodeStubInterface, mortgageNumber int) ([]MortgageVersion, error) {
+	var history []MortgageVersion
+
+	index, err := t.get_mortgage_history_index(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	for sequence := 0; sequence < index.Versions; sequence++ {
+		var version MortgageVersion
+
+		bytes, err := stub.GetState(mortgage_version_key(mortgageNumber, sequence))
+		if err != nil || bytes == nil {
+			return nil, errors.New("error while fetching history of mortgage " + strconv.Itoa(mortgageNumber))
+		}
+		err = json.Unmarshal(bytes, &version)
+		if err != nil {
+			return nil, errors.New("error while Unmarshalling history of mortgage " + strconv.Itoa(mortgageNumber))
+		}
+		history = append(history, version)
+	}
+	return history, nil
+}
+
+//==============================================================================================================================
+//	get_mortgage_history_index - Retrieves the index of the history of a mortgage, empty when nothing was stored yet.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_mortgage_history_index(stub shim.ChaincodeStubInterface, mortgageNumber int) (MortgageHistory, error) {
+	var index MortgageHistory
+
+	bytes, err := stub.GetState(mortgage_history_key(mortgageNumber))
+	if err != nil {
+		return index, errors.New("error while fetching history of mortgage " + strconv.Itoa(mortgageNumber))
+	}
+	if bytes == nil {
+		return index, nil
+	}
+
+	err = json.Unmarshal(bytes, &index)
+	if err != nil {
+		return index, errors.New("error while Unmarshalling history of mortgage " + strconv.Itoa(mortgageNumber))
+	}
+	return index, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPortfolioReportOfMigratedMortgage(t *testing.T) {
+	stub := new_test_stub(t)
+	stub.State["mortgages"] = []byte(`{"MortgageNumbers":[1000001],"CustomerNames":["Old Joe"],"MortgageStages":["Pending-Bank:"],"ConformedMortgages":[false]}`)
+	stub.State[string(rune(1000001))] = []byte(`{"MortgageNumber":1000001,"CustomerName":"Old Joe","MortgageStage":"Pending-Bank:","ReqLoanAmount":9,"RemainingMortgageAmount":9}`)
+
+	// The migration stores the first version of the old mortgage, the new one is created at the same time.
+	stub.caller("root", ADMIN)
+	test_invoke(t, stub, "migrate_mortgages")
+	stub.caller("council", CITY_COUNCIL)
+	test_invoke(t, stub, "register_property", `{"ParcelID":"P1","PropertyAddress":"1 Main","TitleHolder":"seller"}`)
+	stub.caller("bob", CUSTOMER)
+	test_invoke(t, stub, "create_customer", `{"CustomerID":"bob","CustomerName":"Bob"}`)
+	test_invoke(t, stub, "create_mortgage_application", `{"CustomerID":"bob","PropertyID":"P1","ReqLoanAmount":200000}`)
+
+	tests := []struct {
+		asOf           string
+		count          int
+		withoutHistory []int
+	}{
+		{asOf: "2023-11-01", count: 0, withoutHistory: []int{1000001}},
+		{asOf: "2023-11-14", count: 2, withoutHistory: nil},
+	}
+	stub.caller("auditor", AUDITOR)
+	for _, test := range tests {
+		var report PortfolioReport
+		bytes, err := new(SimpleChaincode).Query(stub, "audit_portfolio_report", []string{test.asOf})
+		if err != nil {
+			t.Fatalf("as of %s: %v", test.asOf, err)
+		}
+		json.Unmarshal(bytes, &report)
+		if report.MortgageCount != test.count || !reflect.DeepEqual(report.WithoutHistory, test.withoutHistory) {
+			t.Errorf("as of %s: %d mortgages, %v without history, want %d and %v", test.asOf, report.MortgageCount, report.WithoutHistory, test.count, test.withoutHistory)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+/*
+Dream Mortgage Chaincode - Brokers and Commissions
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	COMMISSION STATUS
+//==============================================================================================================================
+const COMMISSION_ACCRUED = "ACCRUED"
+const COMMISSION_PAID = "PAID"
+
+//==============================================================================================================================
+//	CommissionSchedule - Commission paid to brokers on disbursement: FlatFee plus Rate percent of the granted loan amount
+//			  taken from the tier with the highest MinLoanAmount not above the granted loan amount.
+//==============================================================================================================================
+type CommissionSchedule struct {
+	FlatFee   int              `json:"FlatFee"`
+	Tiers     []CommissionTier `json:"Tiers"`
+	UpdatedBy string           `json:"UpdatedBy"`
+}
+
+type CommissionTier struct {
+	MinLoanAmount int     `json:"MinLoanAmount"`
+	Rate          float32 `json:"Rate"`
+}
+
+//==============================================================================================================================
+//	BrokerAccount - Holds the commissions earned by a broker.
+//==============================================================================================================================
+type BrokerAccount struct {
+	BrokerID    string       `json:"BrokerID"`
+	Commissions []Commission `json:"Commissions"`
+}
+
+type Commission struct {
+	MortgageNumber int    `json:"MortgageNumber"`
+	LoanAmount     int    `json:"LoanAmount"`
+	Amount         int    `json:"Amount"`
+	Status         string `json:"Status"`
+	AccruedTxID    string `json:"AccruedTxID"`
+	PaidTxID       string `json:"PaidTxID"`
+	PaidBy         string `json:"PaidBy"`
+}
+
+//==============================================================================================================================
+//	BrokerStatement - Returned by retrieve_broker_statement.
+//==============================================================================================================================
+type BrokerStatement struct {
+	BrokerID     string       `json:"BrokerID"`
+	Commissions  []Commission `json:"Commissions"`
+	TotalAccrued int          `json:"TotalAccrued"`
+	TotalPaid    int          `json:"TotalPaid"`
+	Outstanding  int          `json:"Outstanding"`
+}
+
+func broker_key(brokerID string) string {
+	return "broker_" + brokerID
+}
+
+func (t *SimpleChaincode) set_commission_schedule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var schedule CommissionSchedule
+
+	//Logging
+	fmt.Println("running set_commission_schedule()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting one JSON commission schedule")
+	}
+	err := json.Unmarshal([]byte(args[0]), &schedule)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling commission schedule json object")
+	}
+	if schedule.FlatFee < 0 {
+		return nil, errors.New("FlatFee can not be negative")
+	}
+	for _, tier := range schedule.Tiers {
+		if tier.MinLoanAmount < 0 || tier.Rate < 0 {
+			return nil, errors.New("Commission tiers can not be negative")
+		}
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != LENDING_BANK {
+		return nil, errors.New("Permission denied. Only the lending bank can set the commission schedule")
+	}
+	schedule.UpdatedBy = username
+
+	bytes, err := json.Marshal(schedule)
+	if err != nil {
+		return nil, errors.New("Error in Marshalling Commission Schedule record")
+	}
+	err = stub.PutState("commission_schedule", bytes)
+	if err != nil {
+		return nil, errors.New("Error storing Commission Schedule record in blockchain")
+	}
+	return nil, nil
+}
+
+func (t *SimpleChaincode) pay_commission(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running pay_commission()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting broker id and mortgage number")
+	}
+	mortgageNumber, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[1])
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != LENDING_BANK {
+		return nil, errors.New("Permission denied. Only the lending bank can pay commissions")
+	}
+
+	account, err := t.get_broker_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	for i := range account.Commissions {
+		if account.Commissions[i].MortgageNumber != mortgageNumber {
+			continue
+		}
+		if account.Commissions[i].Status != COMMISSION_ACCRUED {
+			return nil, errors.New("Commission on mortgage " + args[1] + " is already paid")
+		}
+		account.Commissions[i].Status = COMMISSION_PAID
+		account.Commissions[i].PaidTxID = stub.GetTxID()
+		account.Commissions[i].PaidBy = username
+		return nil, t.save_broker_account(stub, account)
+	}
+	return nil, errors.New("No commission accrued to broker " + args[0] + " on mortgage " + args[1])
+}
+
+func (t *SimpleChaincode) retrieve_broker_statement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var statement BrokerStatement
+
+	//Logging
+	fmt.Println("running retrieve_broker_statement()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting broker id")
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if (role != BROKER || username != args[0]) && role != LENDING_BANK && role != AUDITOR {
+		return nil, errors.New("Permission denied. Brokers can only retrieve their own statement")
+	}
+
+	account, err := t.get_broker_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	statement.BrokerID = args[0]
+	statement.Commissions = account.Commissions
+	for _, commission := range account.Commissions {
+		statement.TotalAccrued += commission.Amount
+		if commission.Status == COMMISSION_PAID {
+			statement.TotalPaid += commission.Amount
+		}
+	}
+	statement.Outstanding = statement.TotalAccrued - statement.TotalPaid
+
+	bytes, err := json.Marshal(statement)
+	if err != nil {
+		return nil, errors.New("error while marshalling broker statement")
+	}
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	accrue_commission - Accrues the commission of the broker of a mortgage that has just been disbursed.
+//==============================================================================================================================
+func (t *SimpleChaincode) accrue_commission(stub shim.ChaincodeStubInterface, mortgage Mortgage) error {
+	var schedule CommissionSchedule
+
+	bytes, err := stub.GetState("commission_schedule")
+	if err != nil {
+		return errors.New("error while retrieving commission schedule")
+	}
+	if bytes != nil {
+		err = json.Unmarshal(bytes, &schedule)
+		if err != nil {
+			return errors.New("error while Unmarshalling commission schedule")
+		}
+	}
+
+	account, err := t.get_broker_account(stub, mortgage.BrokerID)
+	if err != nil {
+		return err
+	}
+	for _, commission := range account.Commissions {
+		if commission.MortgageNumber == mortgage.MortgageNumber {
+			return nil
+		}
+	}
+
+	account.BrokerID = mortgage.BrokerID
+	account.Commissions = append(account.Commissions, Commission{
+		MortgageNumber: mortgage.MortgageNumber,
+		LoanAmount:     mortgage.GrantedLoanAmount,
+		Amount:         commission_amount(schedule, mortgage.GrantedLoanAmount),
+		Status:         COMMISSION_ACCRUED,
+		AccruedTxID:    stub.GetTxID(),
+	})
+	return t.save_broker_account(stub, account)
+}
+
+//==============================================================================================================================
+//	commission_amount - Calculates the commission on a loan amount from the commission schedule.
+//==============================================================================================================================
+func commission_amount(schedule CommissionSchedule, loanAmount int) int {
+	var rate float32
+	tierAmount := -1
+
+	for _, tier := range schedule.Tiers {
+		if tier.MinLoanAmount <= loanAmount && tier.MinLoanAmount > tierAmount {
+			tierAmount = tier.MinLoanAmount
+			rate = tier.Rate
+		}
+	}
+	return schedule.FlatFee + int(float32(loanAmount)*rate/100)
+}
+
+//==============================================================================================================================
+//	get_broker_account - Retrieves a BrokerAccount record from the blockchain, a broker without commissions has an
+//			  empty account.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_broker_account(stub shim.ChaincodeStubInterface, brokerID string) (BrokerAccount, error) {
+	var account BrokerAccount
+
+	bytes, err := stub.GetState(broker_key(brokerID))
+	if err != nil {
+		return account, errors.New("error while fetching broker " + brokerID)
+	}
+	if bytes == nil {
+		return account, nil
+	}
+
+	err = json.Unmarshal(bytes, &account)
+	if err != nil {
+		return account, errors.New("error while Unmarshalling broker " + brokerID)
+	}
+	return account, nil
+}
+
+//==============================================================================================================================
+//	save_broker_account - Writes a BrokerAccount record to the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_broker_account(stub shim.ChaincodeStubInterface, account BrokerAccount) error {
+	bytes, err := json.Marshal(account)
+	if err != nil {
+		return errors.New("Error in Marshalling Broker record")
+	}
+
+	err = stub.PutState(broker_key(account.BrokerID), bytes)
+	if err != nil {
+		return errors.New("Error storing Broker record in blockchain")
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCommissionAmount(t *testing.T) {
+	tiered := CommissionSchedule{
+		FlatFee: 100,
+		Tiers: []CommissionTier{
+			{MinLoanAmount: 500000, Rate: 0.5},
+			{MinLoanAmount: 0, Rate: 1},
+			{MinLoanAmount: 200000, Rate: 0.75},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		schedule   CommissionSchedule
+		loanAmount int
+		want       int
+	}{
+		{name: "no schedule", schedule: CommissionSchedule{}, loanAmount: 300000, want: 0},
+		{name: "flat fee only", schedule: CommissionSchedule{FlatFee: 250}, loanAmount: 300000, want: 250},
+		{name: "lowest tier", schedule: tiered, loanAmount: 100000, want: 1100},
+		{name: "tier boundary", schedule: tiered, loanAmount: 200000, want: 1600},
+		{name: "middle tier", schedule: tiered, loanAmount: 300000, want: 2350},
+		{name: "highest tier", schedule: tiered, loanAmount: 1000000, want: 5100},
+		{name: "below every tier", schedule: CommissionSchedule{Tiers: []CommissionTier{{MinLoanAmount: 1000, Rate: 1}}}, loanAmount: 999, want: 0},
+	}
+	for _, test := range tests {
+		if got := commission_amount(test.schedule, test.loanAmount); got != test.want {
+			t.Errorf("%s: commission_amount(%d) = %d, want %d", test.name, test.loanAmount, got, test.want)
+		}
+	}
+}
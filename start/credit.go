@@ -0,0 +1,345 @@
+/*
+Dream Mortgage Chaincode - Credit Reports
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	CREDIT REPORT FRESHNESS - Reports older than this are not used when computing risk.
+//==============================================================================================================================
+const CREDIT_REPORT_MAX_AGE_DAYS = 90
+
+//==============================================================================================================================
+//	DataProvider - A data service provider allowed to submit credit reports. Certificate holds the base64 encoded
+//			  certificate used to verify the signature of the reports it submits.
+//==============================================================================================================================
+type DataProvider struct {
+	ProviderID   string `json:"ProviderID"`
+	Name         string `json:"Name"`
+	Certificate  string `json:"Certificate"`
+	Active       bool   `json:"Active"`
+	RegisteredBy string `json:"RegisteredBy"`
+}
+
+//==============================================================================================================================
+//	CreditReport - A credit report on a customer posted by a data service provider.
+//==============================================================================================================================
+type CreditReport struct {
+	InquiryID      string `json:"InquiryID"`
+	CustomerID     string `json:"CustomerID"`
+	Bureau         string `json:"Bureau"`
+	Score          int    `json:"Score"`
+	FinancialWorth int    `json:"FinancialWorth"`
+	ReportDate     string `json:"ReportDate"`
+	ProviderID     string `json:"ProviderID"`
+}
+
+func data_provider_key(providerID string) string {
+	return "data_provider_" + providerID
+}
+
+func credit_report_key(inquiryID string) string {
+	return "credit_report_" + inquiryID
+}
+
+func (t *SimpleChaincode) register_data_provider(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var provider DataProvider
+
+	//Logging
+	fmt.Println("running register_data_provider()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting one JSON object to register data provider")
+	}
+	err := json.Unmarshal([]byte(args[0]), &provider)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling data provider json object")
+	}
+	if provider.ProviderID == "" {
+		return nil, errors.New("ProviderID is required to register a data provider")
+	}
+	_, err = base64.StdEncoding.DecodeString(provider.Certificate)
+	if err != nil || provider.Certificate == "" {
+		return nil, errors.New("Certificate must be base64 encoded")
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != ADMIN {
+		return nil, errors.New("Permission denied. Only the admin can register data providers")
+	}
+
+	// Registering an existing provider again replaces its certificate.
+	provider.Active = true
+	provider.RegisteredBy = username
+
+	return nil, t.save_data_provider(stub, provider)
+}
+
+func (t *SimpleChaincode) revoke_data_provider(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running revoke_data_provider()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting provider id")
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != ADMIN {
+		return nil, errors.New("Permission denied. Only the admin can revoke data providers")
+	}
+
+	provider, err := t.get_data_provider(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	provider.Active = false
+	provider.RegisteredBy = username
+
+	return nil, t.save_data_provider(stub, provider)
+}
+
+func (t *SimpleChaincode) submit_credit_report(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var report CreditReport
+
+	//Logging
+	fmt.Println("running submit_credit_report()")
+
+	// The report is signed as sent, so the signature is checked against the raw JSON argument.
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting one JSON credit report and its base64 signature")
+	}
+	err := json.Unmarshal([]byte(args[0]), &report)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling credit report json object")
+	}
+	if report.InquiryID == "" || report.Bureau == "" || report.Score <= 0 {
+		return nil, errors.New("Credit report requires InquiryID, Bureau and Score")
+	}
+	report.ReportDate, err = t.validate_date(stub, report.ReportDate)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.StdEncoding.DecodeString(args[1])
+	if err != nil {
+		return nil, errors.New("Signature must be base64 encoded")
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != DATA_PROVIDER {
+		return nil, errors.New("Permission denied. Only data service providers can submit credit reports")
+	}
+
+	provider, err := t.get_data_provider(stub, username)
+	if err != nil {
+		return nil, err
+	}
+	if !provider.Active {
+		return nil, errors.New("Data provider " + provider.ProviderID + " has been revoked")
+	}
+	certificate, err := base64.StdEncoding.DecodeString(provider.Certificate)
+	if err != nil {
+		return nil, errors.New("error while decoding certificate of data provider " + provider.ProviderID)
+	}
+	ok, err := stub.VerifySignature(certificate, signature, []byte(args[0]))
+	if err != nil || !ok {
+		return nil, errors.New("Signature of credit report " + report.InquiryID + " is not valid for data provider " + provider.ProviderID)
+	}
+
+	existing, err := stub.GetState(credit_report_key(report.InquiryID))
+	if err != nil {
+		return nil, errors.New("error while fetching credit report " + report.InquiryID)
+	}
+	if existing != nil {
+		return nil, errors.New("credit report " + report.InquiryID + " already submitted")
+	}
+
+	customer, err := t.get_customer(stub, report.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+
+	report.ProviderID = provider.ProviderID
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		return nil, errors.New("Error in Marshalling Credit Report record")
+	}
+	err = stub.PutState(credit_report_key(report.InquiryID), bytes)
+	if err != nil {
+		return nil, errors.New("Error storing Credit Report record in blockchain")
+	}
+
+	customer.CreditReports = append(customer.CreditReports, report.InquiryID)
+	err = t.save_customer(stub, customer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reprice the risk of every mortgage of the customer with the new report.
+	for _, number := range customer.MortgageNumbers {
+		mortgage, err := t.get_mortgage(stub, number)
+		if err != nil {
+			return nil, err
+		}
+		err = t.update_risk_profile(stub, &mortgage)
+		if err != nil {
+			return nil, err
+		}
+		err = t.save_mortgage(stub, mortgage)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (t *SimpleChaincode) retrieve_credit_reports(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var reports []CreditReport
+
+	//Logging
+	fmt.Println("running retrieve_credit_reports()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting customer id")
+	}
+
+	customer, err := t.get_customer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, inquiryID := range customer.CreditReports {
+		report, err := t.get_credit_report(stub, inquiryID)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+
+	bytes, err := json.Marshal(reports)
+	if err != nil {
+		return nil, errors.New("error while marshalling the credit report list")
+	}
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	apply_credit_report - Sets the credit score and financial worth of a mortgage from the most recent credit report of
+//			  its customer that is not older than CREDIT_REPORT_MAX_AGE_DAYS. Without a fresh report both are cleared
+//			  so the mortgage is not classified on stale data.
+//==============================================================================================================================
+func (t *SimpleChaincode) apply_credit_report(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	var latest time.Time
+
+	mortgage.CreditScore = 0
+	mortgage.FinancialWorth = 0
+
+	// Mortgages created before the customer registry have no credit reports.
+	if mortgage.CustomerID == "" {
+		return nil
+	}
+	customer, err := t.get_mortgage_customer(stub, *mortgage)
+	if err != nil {
+		return err
+	}
+	if len(customer.CreditReports) == 0 {
+		return nil
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+	oldest := now.AddDate(0, 0, -CREDIT_REPORT_MAX_AGE_DAYS)
+
+	for _, inquiryID := range customer.CreditReports {
+		report, err := t.get_credit_report(stub, inquiryID)
+		if err != nil {
+			return err
+		}
+		reportDate, err := parse_date(report.ReportDate)
+		if err != nil || reportDate.Before(oldest) || reportDate.After(now) || reportDate.Before(latest) {
+			continue
+		}
+		latest = reportDate
+		mortgage.CreditScore = report.Score
+		mortgage.FinancialWorth = report.FinancialWorth
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	get_data_provider - Retrieves a DataProvider record from the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_data_provider(stub shim.ChaincodeStubInterface, providerID string) (DataProvider, error) {
+	var provider DataProvider
+
+	bytes, err := stub.GetState(data_provider_key(providerID))
+	if err != nil {
+		return provider, errors.New("error while fetching data provider " + providerID)
+	}
+	if bytes == nil {
+		return provider, errors.New("data provider " + providerID + " is not registered")
+	}
+
+	err = json.Unmarshal(bytes, &provider)
+	if err != nil {
+		return provider, errors.New("error while Unmarshalling data provider " + providerID)
+	}
+	return provider, nil
+}
+
+//==============================================================================================================================
+//	save_data_provider - Writes a DataProvider record to the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_data_provider(stub shim.ChaincodeStubInterface, provider DataProvider) error {
+	bytes, err := json.Marshal(provider)
+	if err != nil {
+		return errors.New("Error in Marshalling Data Provider record")
+	}
+
+	err = stub.PutState(data_provider_key(provider.ProviderID), bytes)
+	if err != nil {
+		return errors.New("Error storing Data Provider record in blockchain")
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	get_credit_report - Retrieves a CreditReport record from the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_credit_report(stub shim.ChaincodeStubInterface, inquiryID string) (CreditReport, error) {
+	var report CreditReport
+
+	bytes, err := stub.GetState(credit_report_key(inquiryID))
+	if err != nil {
+		return report, errors.New("error while fetching credit report " + inquiryID)
+	}
+	if bytes == nil {
+		return report, errors.New("credit report " + inquiryID + " does not exist")
+	}
+
+	err = json.Unmarshal(bytes, &report)
+	if err != nil {
+		return report, errors.New("error while Unmarshalling credit report " + inquiryID)
+	}
+	return report, nil
+}
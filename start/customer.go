@@ -0,0 +1,293 @@
+/*
+Dream Mortgage Chaincode - Customers
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	KYC STATUS
+//==============================================================================================================================
+const KYC_PENDING = "PENDING"
+const KYC_VERIFIED = "VERIFIED"
+const KYC_REJECTED = "REJECTED"
+
+//==============================================================================================================================
+//	Customer - Defines the structure for a Customer object. A customer is stored once under its own key and
+//			  mortgages reference it through CustomerID.
+//==============================================================================================================================
+type Customer struct {
+	CustomerID      string          `json:"CustomerID"`
+	CustomerName    string          `json:"CustomerName"`
+	CustomerAddress string          `json:"CustomerAddress"`
+	CustomerSSN     int             `json:"CustomerSSN"`
+	CustomerDOB     string          `json:"CustomerDOB"`
+	KYCStatus       string          `json:"KYCStatus"`
+	ContactHistory  []ContactRecord `json:"ContactHistory"`
+	CreditReports   []string        `json:"CreditReports"`
+	MortgageNumbers []int           `json:"MortgageNumbers"`
+	ModifiedBy      string          `json:"ModifiedBy"`
+}
+
+//==============================================================================================================================
+//	ContactRecord - A single interaction with a customer e.g. a phone call or a letter sent.
+//==============================================================================================================================
+type ContactRecord struct {
+	Date       string `json:"Date"`
+	Channel    string `json:"Channel"`
+	Notes      string `json:"Notes"`
+	RecordedBy string `json:"RecordedBy"`
+}
+
+//==============================================================================================================================
+//	CustomerExposure - Returned by retrieve_customer_mortgages, all mortgages of a customer with exposure totals.
+//==============================================================================================================================
+type CustomerExposure struct {
+	Customer               Customer   `json:"Customer"`
+	Mortgages              []Mortgage `json:"Mortgages"`
+	TotalReqLoanAmount     int        `json:"TotalReqLoanAmount"`
+	TotalGrantedLoanAmount int        `json:"TotalGrantedLoanAmount"`
+	TotalRemainingAmount   int        `json:"TotalRemainingAmount"`
+	TotalExpectedCashflow  int        `json:"TotalExpectedCashflow"`
+}
+
+func customer_key(customerID string) string {
+	return "customer_" + customerID
+}
+
+func (t *SimpleChaincode) create_customer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var customer Customer
+	var err error
+
+	//Logging
+	fmt.Println("running create_customer()")
+
+	// verify is the Json is sent.
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting one JSON object to create customer")
+	}
+	err = json.Unmarshal([]byte(args[0]), &customer)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling customer json object")
+	}
+	if customer.CustomerID == "" {
+		return nil, errors.New("CustomerID is required to create a customer")
+	}
+	if customer.CustomerDOB != "" {
+		customer.CustomerDOB, err = t.validate_date(stub, customer.CustomerDOB)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	existing, err := stub.GetState(customer_key(customer.CustomerID))
+	if err != nil {
+		return nil, errors.New("error while fetching customer " + customer.CustomerID)
+	}
+	if existing != nil {
+		return nil, errors.New("customer " + customer.CustomerID + " already exists")
+	}
+
+	username, err := t.check_customer_maintainer(stub, customer.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+
+	//setting default values.
+	customer.KYCStatus = KYC_PENDING
+	customer.ContactHistory = nil
+	customer.CreditReports = nil
+	customer.MortgageNumbers = nil
+	customer.ModifiedBy = username
+
+	return nil, t.save_customer(stub, customer)
+}
+
+func (t *SimpleChaincode) update_customer_kyc(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running update_customer_kyc()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting customer id and KYC status")
+	}
+	if args[1] != KYC_PENDING && args[1] != KYC_VERIFIED && args[1] != KYC_REJECTED {
+		return nil, errors.New("Invalid KYC status " + args[1])
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != LENDING_BANK {
+		return nil, errors.New("Permission denied. Only the lending bank can update KYC status")
+	}
+
+	customer, err := t.get_customer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	customer.KYCStatus = args[1]
+	customer.ModifiedBy = username
+
+	return nil, t.save_customer(stub, customer)
+}
+
+func (t *SimpleChaincode) add_customer_contact(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var contact ContactRecord
+
+	//Logging
+	fmt.Println("running add_customer_contact()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting customer id and one JSON contact record")
+	}
+	err := json.Unmarshal([]byte(args[1]), &contact)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling contact json object")
+	}
+
+	// A contact without a date is recorded as of the transaction.
+	if contact.Date == "" {
+		now, err := t.get_tx_time(stub)
+		if err != nil {
+			return nil, err
+		}
+		contact.Date = now.Format(time.RFC3339)
+	} else {
+		contact.Date, err = t.validate_date(stub, contact.Date)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	username, err := t.check_customer_maintainer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	customer, err := t.get_customer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	contact.RecordedBy = username
+	customer.ContactHistory = append(customer.ContactHistory, contact)
+	customer.ModifiedBy = username
+
+	return nil, t.save_customer(stub, customer)
+}
+
+func (t *SimpleChaincode) retrieve_customer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running retrieve_customer()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting customer id")
+	}
+
+	customer, err := t.get_customer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(customer)
+}
+
+func (t *SimpleChaincode) retrieve_customer_mortgages(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var exposure CustomerExposure
+
+	//Logging
+	fmt.Println("running retrieve_customer_mortgages()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting customer id")
+	}
+
+	customer, err := t.get_customer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	exposure.Customer = customer
+
+	for _, number := range customer.MortgageNumbers {
+		mortgage, err := t.get_mortgage(stub, number)
+		if err != nil {
+			return nil, err
+		}
+		exposure.Mortgages = append(exposure.Mortgages, mortgage)
+		exposure.TotalReqLoanAmount += mortgage.ReqLoanAmount
+		exposure.TotalGrantedLoanAmount += mortgage.GrantedLoanAmount
+		exposure.TotalRemainingAmount += mortgage.RemainingMortgageAmount
+		exposure.TotalExpectedCashflow += mortgage.ExpectedAnnualCashflow
+	}
+
+	bytes, err := json.Marshal(exposure)
+	if err != nil {
+		return nil, errors.New("error while marshalling customer exposure")
+	}
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	check_customer_maintainer - Customers are maintained by the lending bank and brokers, a customer can only maintain
+//			  its own record. Customers enrol with their CustomerID as username. Returns the username of the caller.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_customer_maintainer(stub shim.ChaincodeStubInterface, customerID string) (string, error) {
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return "", err
+	}
+	if role != LENDING_BANK && role != BROKER && (role != CUSTOMER || username != customerID) {
+		return "", errors.New("Permission denied. Only the lending bank, a broker or the customer can maintain customer " + customerID)
+	}
+	return username, nil
+}
+
+//==============================================================================================================================
+//	get_customer - Retrieves a Customer record from the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_customer(stub shim.ChaincodeStubInterface, customerID string) (Customer, error) {
+	var customer Customer
+
+	if customerID == "" {
+		return customer, errors.New("CustomerID is required")
+	}
+
+	bytes, err := stub.GetState(customer_key(customerID))
+	if err != nil {
+		return customer, errors.New("error while fetching customer " + customerID)
+	}
+	if bytes == nil {
+		return customer, errors.New("customer " + customerID + " does not exist")
+	}
+
+	err = json.Unmarshal(bytes, &customer)
+	if err != nil {
+		return customer, errors.New("error while Unmarshalling customer " + customerID)
+	}
+	return customer, nil
+}
+
+//==============================================================================================================================
+//	save_customer - Writes a Customer record to the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_customer(stub shim.ChaincodeStubInterface, customer Customer) error {
+	bytes, err := json.Marshal(customer)
+	if err != nil {
+		return errors.New("Error in Marshalling Customer record")
+	}
+
+	err = stub.PutState(customer_key(customer.CustomerID), bytes)
+	if err != nil {
+		return errors.New("Error storing Customer record in blockchain")
+	}
+	return nil
+}
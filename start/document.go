@@ -0,0 +1,334 @@
+/*
+Dream Mortgage Chaincode - Document Attachments
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	DOCUMENT TYPES
+//==============================================================================================================================
+const DOCUMENT_INCOME_PROOF = "INCOME_PROOF"
+const DOCUMENT_TITLE = "TITLE"
+const DOCUMENT_APPRAISAL = "APPRAISAL"
+const DOCUMENT_SIGNED_NOTE = "SIGNED_NOTE"
+const DOCUMENT_OTHER = "OTHER"
+
+var document_types = map[string]bool{
+	DOCUMENT_INCOME_PROOF: true,
+	DOCUMENT_TITLE:        true,
+	DOCUMENT_APPRAISAL:    true,
+	DOCUMENT_SIGNED_NOTE:  true,
+	DOCUMENT_OTHER:        true,
+}
+
+//==============================================================================================================================
+//	Document - A document supporting a mortgage. Only the metadata and the SHA-256 hash of the content are stored on
+//			  the blockchain, the content itself is kept off chain.
+//==============================================================================================================================
+type Document struct {
+	DocumentID     string `json:"DocumentID"`
+	MortgageNumber int    `json:"MortgageNumber"`
+	DocumentType   string `json:"DocumentType"`
+	FileName       string `json:"FileName"`
+	DocumentHash   string `json:"DocumentHash"`
+	UploadedBy     string `json:"UploadedBy"`
+	UploaderRole   string `json:"UploaderRole"`
+	UploadedDate   string `json:"UploadedDate"`
+}
+
+//==============================================================================================================================
+//	DocumentVerification - Returned by verify_document, whether a hash was registered for a mortgage.
+//==============================================================================================================================
+type DocumentVerification struct {
+	MortgageNumber int       `json:"MortgageNumber"`
+	DocumentHash   string    `json:"DocumentHash"`
+	Registered     bool      `json:"Registered"`
+	Document       *Document `json:"Document,omitempty"`
+}
+
+func document_key(documentID string) string {
+	return "document_" + documentID
+}
+
+func (t *SimpleChaincode) attach_document(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var document Document
+
+	//Logging
+	fmt.Println("running attach_document()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and one JSON document")
+	}
+	mortgageNumber, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[0])
+	}
+	err = json.Unmarshal([]byte(args[1]), &document)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling document json object")
+	}
+	if !document_types[document.DocumentType] {
+		return nil, errors.New("Invalid document type " + document.DocumentType)
+	}
+	document.DocumentHash, err = normalise_document_hash(document.DocumentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	mortgage, err := t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	party, err := t.is_mortgage_party(stub, mortgage, username, role)
+	if err != nil {
+		return nil, err
+	}
+	if !party {
+		return nil, errors.New("Permission denied. Only the customer, broker, appraiser or lending bank of a mortgage can attach documents")
+	}
+
+	existing, err := t.find_document(stub, mortgage, document.DocumentHash)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.New("Document " + document.DocumentHash + " is already attached to mortgage " + args[0] + " as " + existing.DocumentID)
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	document.DocumentID = args[0] + "-" + strconv.Itoa(len(mortgage.DocumentIDs)+1)
+	document.MortgageNumber = mortgageNumber
+	document.UploadedBy = username
+	document.UploaderRole = role
+	document.UploadedDate = now.Format(time.RFC3339)
+	err = t.save_document(stub, document)
+	if err != nil {
+		return nil, err
+	}
+
+	mortgage.DocumentIDs = append(mortgage.DocumentIDs, document.DocumentID)
+	return nil, t.save_mortgage(stub, mortgage)
+}
+
+func (t *SimpleChaincode) retrieve_documents(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var documents []Document
+
+	//Logging
+	fmt.Println("running retrieve_documents()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number")
+	}
+	mortgageNumber, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[0])
+	}
+
+	mortgage, err := t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_mortgage_viewer(stub, mortgage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, documentID := range mortgage.DocumentIDs {
+		document, err := t.get_document(stub, documentID)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+
+	bytes, err := json.Marshal(documents)
+	if err != nil {
+		return nil, errors.New("error while marshalling the document list")
+	}
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) verify_document(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running verify_document()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and document hash")
+	}
+	mortgageNumber, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[0])
+	}
+	hash, err := normalise_document_hash(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, err = t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	mortgage, err := t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := t.find_document(stub, mortgage, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(DocumentVerification{
+		MortgageNumber: mortgageNumber,
+		DocumentHash:   hash,
+		Registered:     document != nil,
+		Document:       document,
+	})
+}
+
+//==============================================================================================================================
+//	normalise_document_hash - Document hashes are hex encoded SHA-256 digests, stored in lower case.
+//==============================================================================================================================
+func normalise_document_hash(hash string) (string, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+
+	digest, err := hex.DecodeString(hash)
+	if err != nil || len(digest) != 32 {
+		return "", errors.New("DocumentHash must be a hex encoded SHA-256 digest")
+	}
+	return hash, nil
+}
+
+//==============================================================================================================================
+//	is_mortgage_party - The customer, broker and lending bank of a mortgage and the appraisers it was assigned to.
+//==============================================================================================================================
+func (t *SimpleChaincode) is_mortgage_party(stub shim.ChaincodeStubInterface, mortgage Mortgage, username string, role string) (bool, error) {
+	switch role {
+	case LENDING_BANK:
+		return true, nil
+	case CUSTOMER:
+		return username == mortgage.CustomerID, nil
+	case BROKER:
+		return mortgage.BrokerID != "" && username == mortgage.BrokerID, nil
+	case APPRAISER:
+		for _, appraisalID := range mortgage.AppraisalIDs {
+			appraisal, err := t.get_appraisal(stub, appraisalID)
+			if err != nil {
+				return false, err
+			}
+			if appraisal.Appraiser == username {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+//==============================================================================================================================
+//	check_mortgage_viewer - Besides the parties to a mortgage the auditor and its current holder can see its details.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_mortgage_viewer(stub shim.ChaincodeStubInterface, mortgage Mortgage) error {
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return err
+	}
+
+	party, err := t.is_mortgage_party(stub, mortgage, username, role)
+	if err != nil {
+		return err
+	}
+	if !party && role != AUDITOR && holder_roles[role] != mortgage.MortgagePropertyOwnership {
+		return errors.New("Permission denied. Only the parties to a mortgage, its holder or the auditor can see its details")
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	find_document - Returns the document of a mortgage with the given hash, nil if it was not attached.
+//==============================================================================================================================
+func (t *SimpleChaincode) find_document(stub shim.ChaincodeStubInterface, mortgage Mortgage, hash string) (*Document, error) {
+	for _, documentID := range mortgage.DocumentIDs {
+		document, err := t.get_document(stub, documentID)
+		if err != nil {
+			return nil, err
+		}
+		if document.DocumentHash == hash {
+			return &document, nil
+		}
+	}
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	has_document_type - Whether a document of the given type is attached to a mortgage.
+//==============================================================================================================================
+func (t *SimpleChaincode) has_document_type(stub shim.ChaincodeStubInterface, mortgage Mortgage, documentType string) (bool, error) {
+	for _, documentID := range mortgage.DocumentIDs {
+		document, err := t.get_document(stub, documentID)
+		if err != nil {
+			return false, err
+		}
+		if document.DocumentType == documentType {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//==============================================================================================================================
+//	get_document - Retrieves a Document record from the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_document(stub shim.ChaincodeStubInterface, documentID string) (Document, error) {
+	var document Document
+
+	bytes, err := stub.GetState(document_key(documentID))
+	if err != nil {
+		return document, errors.New("error while fetching document " + documentID)
+	}
+	if bytes == nil {
+		return document, errors.New("document " + documentID + " does not exist")
+	}
+
+	err = json.Unmarshal(bytes, &document)
+	if err != nil {
+		return document, errors.New("error while Unmarshalling document " + documentID)
+	}
+	return document, nil
+}
+
+//==============================================================================================================================
+//	save_document - Writes a Document record to the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_document(stub shim.ChaincodeStubInterface, document Document) error {
+	bytes, err := json.Marshal(document)
+	if err != nil {
+		return errors.New("Error in Marshalling Document record")
+	}
+
+	err = stub.PutState(document_key(document.DocumentID), bytes)
+	if err != nil {
+		return errors.New("Error storing Document record in blockchain")
+	}
+	return nil
+}
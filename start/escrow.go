@@ -0,0 +1,360 @@
+/*
+Dream Mortgage Chaincode - Escrow Accounts
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	ESCROW ENTRIES
+//==============================================================================================================================
+const ESCROW_CONTRIBUTION = "CONTRIBUTION"
+const ESCROW_DISBURSEMENT = "DISBURSEMENT"
+
+//==============================================================================================================================
+//	ESCROW PAYEES
+//==============================================================================================================================
+const PAYEE_TAX_AUTHORITY = "TAX_AUTHORITY"
+const PAYEE_INSURER = "INSURER"
+
+//==============================================================================================================================
+//	ESCROW_CUSHION_MONTHS - Months of escrow disbursements kept in the account as a cushion.
+//==============================================================================================================================
+const ESCROW_CUSHION_MONTHS = 2
+
+//==============================================================================================================================
+//	EscrowAccount - The escrow sub-ledger of a mortgage. MonthlyContribution is taken out of every payment and the
+//			  property taxes and insurance are paid out of the Balance.
+//==============================================================================================================================
+type EscrowAccount struct {
+	MortgageNumber      int           `json:"MortgageNumber"`
+	AnnualTaxes         int           `json:"AnnualTaxes"`
+	AnnualInsurance     int           `json:"AnnualInsurance"`
+	MonthlyContribution int           `json:"MonthlyContribution"`
+	Balance             int           `json:"Balance"`
+	Entries             []EscrowEntry `json:"Entries"`
+	UpdatedBy           string        `json:"UpdatedBy"`
+}
+
+type EscrowEntry struct {
+	Type       string `json:"Type"`
+	Amount     int    `json:"Amount"`
+	Payee      string `json:"Payee"`
+	PayeeType  string `json:"PayeeType"`
+	Date       string `json:"Date"`
+	TxID       string `json:"TxID"`
+	RecordedBy string `json:"RecordedBy"`
+}
+
+//==============================================================================================================================
+//	EscrowAnalysis - Returned by escrow_analysis, the escrow balance projected over the next twelve months against the
+//			  required cushion. A projected balance below the cushion is a shortage, above it a surplus.
+//==============================================================================================================================
+type EscrowAnalysis struct {
+	MortgageNumber         int    `json:"MortgageNumber"`
+	AnalysisDate           string `json:"AnalysisDate"`
+	Balance                int    `json:"Balance"`
+	ContributionsLastYear  int    `json:"ContributionsLastYear"`
+	DisbursementsLastYear  int    `json:"DisbursementsLastYear"`
+	ProjectedContributions int    `json:"ProjectedContributions"`
+	ProjectedDisbursements int    `json:"ProjectedDisbursements"`
+	ProjectedBalance       int    `json:"ProjectedBalance"`
+	RequiredCushion        int    `json:"RequiredCushion"`
+	Shortage               int    `json:"Shortage"`
+	Surplus                int    `json:"Surplus"`
+	NewMonthlyContribution int    `json:"NewMonthlyContribution"`
+}
+
+func escrow_key(mortgageNumber int) string {
+	return "escrow_" + strconv.Itoa(mortgageNumber)
+}
+
+func (t *SimpleChaincode) set_escrow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var terms EscrowAccount
+
+	//Logging
+	fmt.Println("running set_escrow()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and one JSON escrow object")
+	}
+	mortgageNumber, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[0])
+	}
+	err = json.Unmarshal([]byte(args[1]), &terms)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling escrow json object")
+	}
+	if terms.AnnualTaxes < 0 || terms.AnnualInsurance < 0 {
+		return nil, errors.New("AnnualTaxes and AnnualInsurance can not be negative")
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != LENDING_BANK {
+		return nil, errors.New("Permission denied. Only the lending bank can set up escrow")
+	}
+
+	_, err = t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	account, err := t.get_escrow_account(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// The balance and entries of an existing account are kept when its estimates change.
+	account.MortgageNumber = mortgageNumber
+	account.AnnualTaxes = terms.AnnualTaxes
+	account.AnnualInsurance = terms.AnnualInsurance
+	account.MonthlyContribution = monthly_escrow(account.AnnualTaxes + account.AnnualInsurance)
+	account.UpdatedBy = username
+
+	return nil, t.save_escrow_account(stub, account)
+}
+
+func (t *SimpleChaincode) disburse_escrow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var entry EscrowEntry
+
+	//Logging
+	fmt.Println("running disburse_escrow()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and one JSON disbursement")
+	}
+	mortgageNumber, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[0])
+	}
+	err = json.Unmarshal([]byte(args[1]), &entry)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling disbursement json object")
+	}
+	if entry.Amount <= 0 || entry.Payee == "" {
+		return nil, errors.New("Escrow disbursement requires a positive Amount and a Payee")
+	}
+	if entry.PayeeType != PAYEE_TAX_AUTHORITY && entry.PayeeType != PAYEE_INSURER {
+		return nil, errors.New("Invalid payee type " + entry.PayeeType)
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != LENDING_BANK {
+		return nil, errors.New("Permission denied. Only the lending bank can disburse escrow")
+	}
+
+	account, err := t.get_escrow_account(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	if account.MortgageNumber == 0 {
+		return nil, errors.New("Mortgage " + args[0] + " has no escrow account")
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Taxes and insurance are paid when due, a disbursement above the balance leaves the account in shortage.
+	entry.Type = ESCROW_DISBURSEMENT
+	entry.Date = now.Format(time.RFC3339)
+	entry.TxID = stub.GetTxID()
+	entry.RecordedBy = username
+	account.Balance -= entry.Amount
+	account.Entries = append(account.Entries, entry)
+
+	return nil, t.save_escrow_account(stub, account)
+}
+
+func (t *SimpleChaincode) retrieve_escrow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running retrieve_escrow()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number")
+	}
+	account, err := t.get_viewable_escrow(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(account)
+}
+
+func (t *SimpleChaincode) escrow_analysis(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running escrow_analysis()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number")
+	}
+	account, err := t.get_viewable_escrow(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	annual := account.AnnualTaxes + account.AnnualInsurance
+	analysis := EscrowAnalysis{
+		MortgageNumber:         account.MortgageNumber,
+		AnalysisDate:           now.Format(time.RFC3339),
+		Balance:                account.Balance,
+		ProjectedContributions: 12 * account.MonthlyContribution,
+		ProjectedDisbursements: annual,
+		RequiredCushion:        annual * ESCROW_CUSHION_MONTHS / 12,
+	}
+
+	yearAgo := now.AddDate(-1, 0, 0)
+	for _, entry := range account.Entries {
+		date, err := time.Parse(time.RFC3339, entry.Date)
+		if err != nil || !date.After(yearAgo) {
+			continue
+		}
+		if entry.Type == ESCROW_CONTRIBUTION {
+			analysis.ContributionsLastYear += entry.Amount
+		} else {
+			analysis.DisbursementsLastYear += entry.Amount
+		}
+	}
+
+	analysis.ProjectedBalance = analysis.Balance + analysis.ProjectedContributions - analysis.ProjectedDisbursements
+	difference := analysis.ProjectedBalance - analysis.RequiredCushion
+	if difference < 0 {
+		analysis.Shortage = -difference
+	} else {
+		analysis.Surplus = difference
+	}
+
+	// A shortage is collected over the next twelve months on top of the contribution for the projected disbursements.
+	analysis.NewMonthlyContribution = monthly_escrow(annual) + monthly_escrow(analysis.Shortage)
+
+	bytes, err := json.Marshal(analysis)
+	if err != nil {
+		return nil, errors.New("error while marshalling escrow analysis")
+	}
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	collect_escrow - Takes the monthly escrow contribution out of a payment, returns the amount taken.
+//==============================================================================================================================
+func (t *SimpleChaincode) collect_escrow(stub shim.ChaincodeStubInterface, mortgageNumber int, payment int) (int, error) {
+	account, err := t.get_escrow_account(stub, mortgageNumber)
+	if err != nil {
+		return 0, err
+	}
+	if account.MortgageNumber == 0 || account.MonthlyContribution <= 0 {
+		return 0, nil
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return 0, err
+	}
+
+	contribution := account.MonthlyContribution
+	if contribution > payment {
+		contribution = payment
+	}
+	account.Balance += contribution
+	account.Entries = append(account.Entries, EscrowEntry{
+		Type:   ESCROW_CONTRIBUTION,
+		Amount: contribution,
+		Date:   now.Format(time.RFC3339),
+		TxID:   stub.GetTxID(),
+	})
+
+	return contribution, t.save_escrow_account(stub, account)
+}
+
+func monthly_escrow(annual int) int {
+	return (annual + 11) / 12
+}
+
+//==============================================================================================================================
+//	get_viewable_escrow - Retrieves the escrow account of a mortgage for a caller allowed to see the mortgage.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_viewable_escrow(stub shim.ChaincodeStubInterface, number string) (EscrowAccount, error) {
+	var account EscrowAccount
+
+	mortgageNumber, err := strconv.Atoi(number)
+	if err != nil {
+		return account, errors.New("Invalid mortgage number " + number)
+	}
+	mortgage, err := t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return account, err
+	}
+	err = t.check_mortgage_viewer(stub, mortgage)
+	if err != nil {
+		return account, err
+	}
+
+	account, err = t.get_escrow_account(stub, mortgageNumber)
+	if err != nil {
+		return account, err
+	}
+	if account.MortgageNumber == 0 {
+		return account, errors.New("Mortgage " + number + " has no escrow account")
+	}
+	return account, nil
+}
+
+//==============================================================================================================================
+//	get_escrow_account - Retrieves the EscrowAccount of a mortgage from the blockchain, a mortgage without escrow has an
+//			  empty account.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_escrow_account(stub shim.ChaincodeStubInterface, mortgageNumber int) (EscrowAccount, error) {
+	var account EscrowAccount
+
+	bytes, err := stub.GetState(escrow_key(mortgageNumber))
+	if err != nil {
+		return account, errors.New("error while fetching escrow of mortgage " + strconv.Itoa(mortgageNumber))
+	}
+	if bytes == nil {
+		return account, nil
+	}
+
+	err = json.Unmarshal(bytes, &account)
+	if err != nil {
+		return account, errors.New("error while Unmarshalling escrow of mortgage " + strconv.Itoa(mortgageNumber))
+	}
+	return account, nil
+}
+
+//==============================================================================================================================
+//	save_escrow_account - Writes an EscrowAccount record to the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_escrow_account(stub shim.ChaincodeStubInterface, account EscrowAccount) error {
+	bytes, err := json.Marshal(account)
+	if err != nil {
+		return errors.New("Error in Marshalling Escrow record")
+	}
+
+	err = stub.PutState(escrow_key(account.MortgageNumber), bytes)
+	if err != nil {
+		return errors.New("Error storing Escrow record in blockchain")
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+/*
+Dream Mortgage Chaincode - Interest Accrual and Fees
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	DAY COUNT CONVENTIONS
+//==============================================================================================================================
+const DAY_COUNT_30_360 = "30/360"
+const DAY_COUNT_ACT_365 = "ACT/365"
+const DAY_COUNT_ACT_ACT = "ACT/ACT"
+
+var day_count_conventions = map[string]bool{
+	DAY_COUNT_30_360:  true,
+	DAY_COUNT_ACT_365: true,
+	DAY_COUNT_ACT_ACT: true,
+}
+
+//==============================================================================================================================
+//	FeeRecord - A fee assessed on a disbursed mortgage, it is added to the remaining amount until paid.
+//==============================================================================================================================
+type FeeRecord struct {
+	Amount       int    `json:"Amount"`
+	Reason       string `json:"Reason"`
+	AssessedDate string `json:"AssessedDate"`
+	AssessedBy   string `json:"AssessedBy"`
+	TxID         string `json:"TxID"`
+}
+
+func (t *SimpleChaincode) accrue_interest(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running accrue_interest()")
+
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and an optional accrual date")
+	}
+
+	mortgage, err := t.get_servicing_mortgage(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+	to := now
+	if len(args) == 2 {
+		to, err = parse_date(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if to.After(now) {
+			return nil, errors.New("Interest can not be accrued beyond the transaction date")
+		}
+	}
+
+	err = accrue_mortgage_interest(&mortgage, to)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.update_risk_profile(stub, &mortgage)
+	if err != nil {
+		return nil, err
+	}
+	return nil, t.save_mortgage(stub, mortgage)
+}
+
+func (t *SimpleChaincode) assess_fee(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var fee FeeRecord
+
+	//Logging
+	fmt.Println("running assess_fee()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and one JSON fee")
+	}
+	err := json.Unmarshal([]byte(args[1]), &fee)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling fee json object")
+	}
+	if fee.Amount <= 0 || fee.Reason == "" {
+		return nil, errors.New("A fee requires a positive Amount and a Reason")
+	}
+
+	mortgage, err := t.get_servicing_mortgage(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	username, _, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	fee.AssessedDate = now.Format(time.RFC3339)
+	fee.AssessedBy = username
+	fee.TxID = stub.GetTxID()
+	mortgage.Fees = append(mortgage.Fees, fee)
+	mortgage.OutstandingFees += fee.Amount
+	mortgage.RemainingMortgageAmount += fee.Amount
+
+	err = t.update_risk_profile(stub, &mortgage)
+	if err != nil {
+		return nil, err
+	}
+	return nil, t.save_mortgage(stub, mortgage)
+}
+
+//==============================================================================================================================
+//	accrue_mortgage_interest - Accrues simple interest on the principal balance of a mortgage, less its deferred
+//			  principal, from the date interest was last accrued to the given time.
+//==============================================================================================================================
+func accrue_mortgage_interest(mortgage *Mortgage, to time.Time) error {
+	if !strings.Contains(strings.ToUpper(mortgage.MortgageStage), "DISBURSED:") {
+		return nil
+	}
+
+	// Mortgages disbursed before interest was accrued start accruing now.
+	if mortgage.InterestAccruedTo == "" {
+		mortgage.InterestAccruedTo = to.Format(time.RFC3339)
+		return nil
+	}
+
+	from, err := parse_date(mortgage.InterestAccruedTo)
+	if err != nil {
+		return err
+	}
+	if to.Before(from) {
+		return errors.New("Interest of mortgage " + strconv.Itoa(mortgage.MortgageNumber) + " is already accrued to " + mortgage.InterestAccruedTo)
+	}
+
+	balance := principal_balance(*mortgage) - mortgage.DeferredPrincipal
+	if balance > 0 {
+		fraction := year_fraction(mortgage.DayCountConvention, from, to)
+		interest := int(math.Round(float64(balance) * float64(mortgage.RateofInterest) / 100 * fraction))
+		mortgage.AccruedInterest += interest
+		mortgage.RemainingMortgageAmount += interest
+	}
+	mortgage.InterestAccruedTo = to.Format(time.RFC3339)
+	return nil
+}
+
+//==============================================================================================================================
+//	year_fraction - The fraction of a year between two times under a day count convention, ACT/365 if none is set.
+//==============================================================================================================================
+func year_fraction(convention string, from time.Time, to time.Time) float64 {
+	switch convention {
+	case DAY_COUNT_30_360:
+		d1, d2 := from.Day(), to.Day()
+		if d1 == 31 {
+			d1 = 30
+		}
+		if d2 == 31 && d1 == 30 {
+			d2 = 30
+		}
+		days := 360*(to.Year()-from.Year()) + 30*(int(to.Month())-int(from.Month())) + d2 - d1
+		return float64(days) / 360
+	case DAY_COUNT_ACT_ACT:
+		// Each calendar year of the period counts against its own length.
+		fraction := 0.0
+		for start := from; start.Before(to); {
+			yearStart := time.Date(start.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+			yearEnd := yearStart.AddDate(1, 0, 0)
+			end := to
+			if yearEnd.Before(end) {
+				end = yearEnd
+			}
+			fraction += end.Sub(start).Hours() / yearEnd.Sub(yearStart).Hours()
+			start = end
+		}
+		return fraction
+	default:
+		return to.Sub(from).Hours() / 24 / 365
+	}
+}
+
+//==============================================================================================================================
+//	principal_balance - The principal owed on a mortgage, its remaining amount less accrued interest and fees.
+//==============================================================================================================================
+func principal_balance(mortgage Mortgage) int {
+	return mortgage.RemainingMortgageAmount - mortgage.AccruedInterest - mortgage.OutstandingFees
+}
+
+//==============================================================================================================================
+//	get_servicing_mortgage - Interest and fees are applied by the lending bank to disbursed mortgages.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_servicing_mortgage(stub shim.ChaincodeStubInterface, number string) (Mortgage, error) {
+	var mortgage Mortgage
+
+	mortgageNumber, err := strconv.Atoi(number)
+	if err != nil {
+		return mortgage, errors.New("Invalid mortgage number " + number)
+	}
+
+	_, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return mortgage, err
+	}
+	if role != LENDING_BANK {
+		return mortgage, errors.New("Permission denied. Only the lending bank can service mortgages")
+	}
+
+	mortgage, err = t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return mortgage, err
+	}
+	if !strings.Contains(strings.ToUpper(mortgage.MortgageStage), "DISBURSED:") {
+		return mortgage, errors.New("Mortgage " + number + " is not disbursed")
+	}
+	return mortgage, nil
+}
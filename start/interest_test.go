@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestYearFraction(t *testing.T) {
+	date := func(value string) time.Time {
+		parsed, _ := time.Parse("2006-01-02", value)
+		return parsed
+	}
+
+	tests := []struct {
+		convention string
+		from       string
+		to         string
+		want       float64
+	}{
+		{convention: DAY_COUNT_ACT_365, from: "2023-01-01", to: "2024-01-01", want: 1},
+		{convention: DAY_COUNT_ACT_365, from: "2024-01-01", to: "2025-01-01", want: 366.0 / 365},
+		{convention: "", from: "2023-01-01", to: "2023-01-31", want: 30.0 / 365},
+		{convention: DAY_COUNT_30_360, from: "2023-01-31", to: "2023-03-31", want: 60.0 / 360},
+		{convention: DAY_COUNT_30_360, from: "2023-02-28", to: "2023-03-31", want: 33.0 / 360},
+		{convention: DAY_COUNT_30_360, from: "2023-01-15", to: "2024-01-15", want: 1},
+		{convention: DAY_COUNT_ACT_ACT, from: "2024-01-01", to: "2025-01-01", want: 1},
+		{convention: DAY_COUNT_ACT_ACT, from: "2023-07-01", to: "2024-07-01", want: 184.0/365 + 182.0/366},
+		{convention: DAY_COUNT_ACT_ACT, from: "2023-03-01", to: "2023-03-01", want: 0},
+	}
+	for _, test := range tests {
+		got := year_fraction(test.convention, date(test.from), date(test.to))
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("year_fraction(%q, %s, %s) = %v, want %v", test.convention, test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestPrincipalBalance(t *testing.T) {
+	tests := []struct {
+		mortgage Mortgage
+		want     int
+	}{
+		{mortgage: Mortgage{RemainingMortgageAmount: 1000}, want: 1000},
+		{mortgage: Mortgage{RemainingMortgageAmount: 1000, AccruedInterest: 30, OutstandingFees: 20}, want: 950},
+		{mortgage: Mortgage{RemainingMortgageAmount: 50, AccruedInterest: 30, OutstandingFees: 20}, want: 0},
+	}
+	for _, test := range tests {
+		if got := principal_balance(test.mortgage); got != test.want {
+			t.Errorf("principal_balance(%+v) = %d, want %d", test.mortgage, got, test.want)
+		}
+	}
+}
+
+func TestApplyPayment(t *testing.T) {
+	tests := []struct {
+		amount    int
+		fees      int
+		interest  int
+		principal int
+		remaining int
+		refused   bool
+	}{
+		{amount: 10, fees: 10, remaining: 990},
+		{amount: 40, fees: 20, interest: 20, remaining: 960},
+		{amount: 100, fees: 20, interest: 30, principal: 50, remaining: 900},
+		{amount: 1000, fees: 20, interest: 30, principal: 950, remaining: 0},
+		{amount: 1001, refused: true},
+	}
+	for _, test := range tests {
+		stub := new_test_stub(t)
+		mortgage := Mortgage{MortgageNumber: 1000001, MortgageStage: "Disbursed:", RemainingMortgageAmount: 1000, AccruedInterest: 30, OutstandingFees: 20}
+
+		err := new(SimpleChaincode).apply_payment(stub, &mortgage, test.amount)
+		if test.refused {
+			if err == nil {
+				t.Errorf("payment of %d: applied, want it refused as more than due", test.amount)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("payment of %d: %v", test.amount, err)
+			continue
+		}
+		payment := mortgage.Payments[len(mortgage.Payments)-1]
+		if payment.Amount != test.amount || payment.Fees != test.fees || payment.Interest != test.interest || payment.Principal != test.principal {
+			t.Errorf("payment of %d recorded as %+v, want fees %d, interest %d, principal %d", test.amount, payment, test.fees, test.interest, test.principal)
+		}
+		if mortgage.RemainingMortgageAmount != test.remaining {
+			t.Errorf("payment of %d: RemainingMortgageAmount = %d, want %d", test.amount, mortgage.RemainingMortgageAmount, test.remaining)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	gp "google/protobuf"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	test_stub - A MockStub that also answers the certificate attributes of the caller and the transaction timestamp.
+//==============================================================================================================================
+type test_stub struct {
+	*shim.MockStub
+	attributes map[string]string
+	seconds    int64
+}
+
+func new_test_stub(t *testing.T) *test_stub {
+	stub := &test_stub{
+		MockStub:   shim.NewMockStub("dream_mortgage", new(SimpleChaincode)),
+		attributes: map[string]string{},
+		seconds:    1700000000,
+	}
+	stub.MockTransactionStart("tx1")
+
+	_, err := new(SimpleChaincode).Init(stub, "init", nil)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return stub
+}
+
+func (stub *test_stub) caller(username string, role string) *test_stub {
+	stub.attributes["username"] = username
+	stub.attributes["role"] = role
+	return stub
+}
+
+func (stub *test_stub) ReadCertAttribute(attributeName string) ([]byte, error) {
+	value, found := stub.attributes[attributeName]
+	if !found {
+		return nil, errors.New("no attribute " + attributeName)
+	}
+	return []byte(value), nil
+}
+
+func (stub *test_stub) GetTxTimestamp() (*gp.Timestamp, error) {
+	return &gp.Timestamp{Seconds: stub.seconds}, nil
+}
+
+func test_invoke(t *testing.T, stub *test_stub, function string, args ...string) []byte {
+	t.Helper()
+	bytes, err := new(SimpleChaincode).Invoke(stub, function, args)
+	if err != nil {
+		t.Fatalf("%s: %v", function, err)
+	}
+	return bytes
+}
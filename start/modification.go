@@ -0,0 +1,402 @@
+/*
+Dream Mortgage Chaincode - Loan Modifications and Forbearance
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	PLAN TYPES
+//==============================================================================================================================
+const PLAN_MODIFICATION = "MODIFICATION"
+const PLAN_FORBEARANCE = "FORBEARANCE"
+
+//==============================================================================================================================
+//	DELINQUENCY STATUS
+//==============================================================================================================================
+const LOAN_CURRENT = "CURRENT"
+const LOAN_IN_FORBEARANCE = "IN_FORBEARANCE"
+const LOAN_PAST_DUE = "PAST_DUE"
+const LOAN_DELINQUENT_30 = "DELINQUENT_30"
+const LOAN_DELINQUENT_60 = "DELINQUENT_60"
+const LOAN_DELINQUENT_90 = "DELINQUENT_90"
+
+//==============================================================================================================================
+//	PAYMENT_CYCLE_DAYS - Days between scheduled payments, a mortgage is past due this long after its last payment.
+//==============================================================================================================================
+const PAYMENT_CYCLE_DAYS = 30
+
+//==============================================================================================================================
+//	LoanModification - A version of the terms of a disbursed mortgage. A MODIFICATION reduces the rate, extends the
+//			  duration and/or defers principal from the effective date onwards. A FORBEARANCE replaces the
+//			  scheduled payment by ForbearancePayment from ForbearanceStart to ForbearanceEnd inclusive.
+//==============================================================================================================================
+type LoanModification struct {
+	Version            int     `json:"Version"`
+	Type               string  `json:"Type"`
+	RateofInterest     float32 `json:"RateofInterest"`
+	PreviousRate       float32 `json:"PreviousRate"`
+	TermExtensionDays  int     `json:"TermExtensionDays"`
+	DeferredPrincipal  int     `json:"DeferredPrincipal"`
+	ForbearanceStart   string  `json:"ForbearanceStart"`
+	ForbearanceEnd     string  `json:"ForbearanceEnd"`
+	ForbearancePayment int     `json:"ForbearancePayment"`
+	EffectiveDate      string  `json:"EffectiveDate"`
+	Reason             string  `json:"Reason"`
+	ApprovedBy         string  `json:"ApprovedBy"`
+	TxID               string  `json:"TxID"`
+}
+
+//==============================================================================================================================
+//	LoanStatus - Returned by retrieve_loan_status, the amortization and delinquency of a mortgage under its current
+//			  terms and active forbearance plan.
+//==============================================================================================================================
+type LoanStatus struct {
+	MortgageNumber    int               `json:"MortgageNumber"`
+	TermsVersion      int               `json:"TermsVersion"`
+	RateofInterest    float32           `json:"RateofInterest"`
+	AmortizingBalance int               `json:"AmortizingBalance"`
+	DeferredPrincipal int               `json:"DeferredPrincipal"`
+	RemainingTermDays int               `json:"RemainingTermDays"`
+	ScheduledPayment  int               `json:"ScheduledPayment"`
+	ActivePlan        *LoanModification `json:"ActivePlan,omitempty"`
+	LastPaymentDate   string            `json:"LastPaymentDate"`
+	DaysPastDue       int               `json:"DaysPastDue"`
+	DelinquencyStatus string            `json:"DelinquencyStatus"`
+}
+
+func (t *SimpleChaincode) modify_loan(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var terms LoanModification
+
+	//Logging
+	fmt.Println("running modify_loan()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and one JSON modification")
+	}
+	err := json.Unmarshal([]byte(args[1]), &terms)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling modification json object")
+	}
+	if terms.RateofInterest < 0 || terms.TermExtensionDays < 0 || terms.DeferredPrincipal < 0 {
+		return nil, errors.New("RateofInterest, TermExtensionDays and DeferredPrincipal can not be negative")
+	}
+	if terms.RateofInterest == 0 && terms.TermExtensionDays == 0 && terms.DeferredPrincipal == 0 {
+		return nil, errors.New("A modification requires a rate reduction, a term extension or a principal deferral")
+	}
+
+	username, mortgage, err := t.get_modifiable_mortgage(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if terms.RateofInterest > 0 && terms.RateofInterest >= mortgage.RateofInterest {
+		return nil, errors.New("A modification can only reduce the rate of interest")
+	}
+	if terms.DeferredPrincipal > principal_balance(mortgage)-mortgage.DeferredPrincipal {
+		return nil, errors.New("DeferredPrincipal can not exceed the amortizing balance of mortgage " + args[0])
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Interest up to the modification is accrued under the old terms.
+	err = accrue_mortgage_interest(&mortgage, now)
+	if err != nil {
+		return nil, err
+	}
+
+	terms.Type = PLAN_MODIFICATION
+	terms.PreviousRate = mortgage.RateofInterest
+	terms.ForbearanceStart = ""
+	terms.ForbearanceEnd = ""
+	terms.ForbearancePayment = 0
+	terms.EffectiveDate = now.Format(time.RFC3339)
+
+	// A modified rate is fixed, an adjustable rate mortgage stops resetting once its rate is modified.
+	if terms.RateofInterest > 0 {
+		mortgage.RateofInterest = terms.RateofInterest
+		mortgage.AdjustableRate = nil
+	}
+	mortgage.MortgageDuration += terms.TermExtensionDays
+	mortgage.DeferredPrincipal += terms.DeferredPrincipal
+
+	return nil, t.add_loan_terms(stub, mortgage, terms, username)
+}
+
+func (t *SimpleChaincode) grant_forbearance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var plan LoanModification
+
+	//Logging
+	fmt.Println("running grant_forbearance()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and one JSON forbearance plan")
+	}
+	err := json.Unmarshal([]byte(args[1]), &plan)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling forbearance json object")
+	}
+	start, end, err := forbearance_period(plan)
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, errors.New("ForbearanceEnd can not be before ForbearanceStart")
+	}
+	if plan.ForbearancePayment < 0 {
+		return nil, errors.New("ForbearancePayment can not be negative")
+	}
+
+	username, mortgage, err := t.get_modifiable_mortgage(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range mortgage.Modifications {
+		if existing.Type != PLAN_FORBEARANCE {
+			continue
+		}
+		existingStart, existingEnd, err := forbearance_period(existing)
+		if err != nil {
+			return nil, err
+		}
+		if !start.After(existingEnd) && !existingStart.After(end) {
+			return nil, errors.New("Forbearance overlaps forbearance version " + strconv.Itoa(existing.Version) + " of mortgage " + args[0])
+		}
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Type = PLAN_FORBEARANCE
+	plan.RateofInterest = 0
+	plan.PreviousRate = mortgage.RateofInterest
+	plan.TermExtensionDays = 0
+	plan.DeferredPrincipal = 0
+	plan.EffectiveDate = now.Format(time.RFC3339)
+
+	return nil, t.add_loan_terms(stub, mortgage, plan, username)
+}
+
+func (t *SimpleChaincode) retrieve_loan_status(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running retrieve_loan_status()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number")
+	}
+	mortgageNumber, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[0])
+	}
+	mortgage, err := t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_mortgage_viewer(stub, mortgage)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	status := LoanStatus{
+		MortgageNumber:    mortgageNumber,
+		TermsVersion:      mortgage.TermsVersion,
+		RateofInterest:    mortgage.RateofInterest,
+		AmortizingBalance: principal_balance(mortgage) - mortgage.DeferredPrincipal,
+		DeferredPrincipal: mortgage.DeferredPrincipal,
+		RemainingTermDays: remaining_term_days(mortgage, now),
+		ScheduledPayment:  scheduled_payment(mortgage, now),
+		ActivePlan:        active_forbearance(mortgage, now),
+	}
+	status.LastPaymentDate, status.DaysPastDue = days_past_due(mortgage, now)
+	status.DelinquencyStatus = delinquency_status(status)
+
+	bytes, err := json.Marshal(status)
+	if err != nil {
+		return nil, errors.New("error while marshalling loan status")
+	}
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	scheduled_payment - The monthly payment amortizing the balance of a mortgage, less its deferred principal, over
+//			  the rest of its duration. During forbearance the payment of the active plan is due instead.
+//==============================================================================================================================
+func scheduled_payment(mortgage Mortgage, now time.Time) int {
+	plan := active_forbearance(mortgage, now)
+	if plan != nil {
+		return plan.ForbearancePayment
+	}
+
+	balance := float64(principal_balance(mortgage) - mortgage.DeferredPrincipal)
+	if balance <= 0 {
+		return 0
+	}
+	months := (remaining_term_days(mortgage, now) + PAYMENT_CYCLE_DAYS - 1) / PAYMENT_CYCLE_DAYS
+	if months < 1 {
+		months = 1
+	}
+
+	rate := float64(mortgage.RateofInterest) / 100 / 12
+	if rate <= 0 {
+		return int(math.Ceil(balance / float64(months)))
+	}
+	return int(math.Ceil(balance * rate / (1 - math.Pow(1+rate, -float64(months)))))
+}
+
+//==============================================================================================================================
+//	days_past_due - Days the next payment of a disbursed mortgage is overdue, returned with the date the days are
+//			  counted from. Days under a forbearance plan are not counted.
+//==============================================================================================================================
+func days_past_due(mortgage Mortgage, now time.Time) (string, int) {
+	if !strings.Contains(strings.ToUpper(mortgage.MortgageStage), "DISBURSED:") || mortgage.RemainingMortgageAmount <= 0 {
+		return "", 0
+	}
+
+	from := mortgage.MortgageStartDate
+	if len(mortgage.Payments) > 0 {
+		from = mortgage.Payments[len(mortgage.Payments)-1].PaymentDate
+	}
+	paid, err := parse_date(from)
+	if err != nil || !paid.Before(now) {
+		return from, 0
+	}
+
+	elapsed := now.Sub(paid)
+	for _, plan := range mortgage.Modifications {
+		if plan.Type != PLAN_FORBEARANCE {
+			continue
+		}
+		start, end, err := forbearance_period(plan)
+		if err != nil {
+			continue
+		}
+		if start.Before(paid) {
+			start = paid
+		}
+		if end.After(now) {
+			end = now
+		}
+		if end.After(start) {
+			elapsed -= end.Sub(start)
+		}
+	}
+
+	overdue := int(elapsed.Hours()/24) - PAYMENT_CYCLE_DAYS
+	if overdue < 0 {
+		overdue = 0
+	}
+	return from, overdue
+}
+
+func delinquency_status(status LoanStatus) string {
+	switch {
+	case status.ActivePlan != nil:
+		return LOAN_IN_FORBEARANCE
+	case status.DaysPastDue <= 0:
+		return LOAN_CURRENT
+	case status.DaysPastDue < 30:
+		return LOAN_PAST_DUE
+	case status.DaysPastDue < 60:
+		return LOAN_DELINQUENT_30
+	case status.DaysPastDue < 90:
+		return LOAN_DELINQUENT_60
+	default:
+		return LOAN_DELINQUENT_90
+	}
+}
+
+//==============================================================================================================================
+//	active_forbearance - The forbearance plan of a mortgage in effect at the given time, nil if there is none.
+//==============================================================================================================================
+func active_forbearance(mortgage Mortgage, now time.Time) *LoanModification {
+	for i := len(mortgage.Modifications) - 1; i >= 0; i-- {
+		plan := mortgage.Modifications[i]
+		if plan.Type != PLAN_FORBEARANCE {
+			continue
+		}
+		start, end, err := forbearance_period(plan)
+		if err == nil && !now.Before(start) && !now.After(end) {
+			return &plan
+		}
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	forbearance_period - Start and end of a forbearance plan, a plain end date covers the whole day.
+//==============================================================================================================================
+func forbearance_period(plan LoanModification) (time.Time, time.Time, error) {
+	start, err := parse_report_time(plan.ForbearanceStart, false)
+	if err != nil {
+		return start, start, err
+	}
+	end, err := parse_report_time(plan.ForbearanceEnd, true)
+	return start, end, err
+}
+
+//==============================================================================================================================
+//	get_modifiable_mortgage - Hardship plans are granted by the lending bank on disbursed mortgages with a balance.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_modifiable_mortgage(stub shim.ChaincodeStubInterface, number string) (string, Mortgage, error) {
+	var mortgage Mortgage
+
+	mortgageNumber, err := strconv.Atoi(number)
+	if err != nil {
+		return "", mortgage, errors.New("Invalid mortgage number " + number)
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return "", mortgage, err
+	}
+	if role != LENDING_BANK {
+		return "", mortgage, errors.New("Permission denied. Only the lending bank can modify loan terms")
+	}
+
+	mortgage, err = t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return "", mortgage, err
+	}
+	if !strings.Contains(strings.ToUpper(mortgage.MortgageStage), "DISBURSED:") || mortgage.RemainingMortgageAmount <= 0 {
+		return "", mortgage, errors.New("Mortgage " + number + " is not disbursed with a remaining balance")
+	}
+	return username, mortgage, nil
+}
+
+//==============================================================================================================================
+//	add_loan_terms - Records a new version of the terms of a mortgage and stores the mortgage.
+//==============================================================================================================================
+func (t *SimpleChaincode) add_loan_terms(stub shim.ChaincodeStubInterface, mortgage Mortgage, terms LoanModification, username string) error {
+	mortgage.TermsVersion++
+	terms.Version = mortgage.TermsVersion
+	terms.ApprovedBy = username
+	terms.TxID = stub.GetTxID()
+	mortgage.Modifications = append(mortgage.Modifications, terms)
+
+	err := t.update_risk_profile(stub, &mortgage)
+	if err != nil {
+		return err
+	}
+	return t.save_mortgage(stub, mortgage)
+}
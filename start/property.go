@@ -0,0 +1,293 @@
+/*
+Dream Mortgage Chaincode - Property Registry
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	LIEN STATUS
+//==============================================================================================================================
+const LIEN_RECORDED = "RECORDED"
+const LIEN_RELEASED = "RELEASED"
+
+//==============================================================================================================================
+//	Property - Defines the structure for a Property object maintained by the city council. Mortgages reference a
+//			  property through PropertyID which is the parcel id of the property.
+//==============================================================================================================================
+type Property struct {
+	ParcelID        string      `json:"ParcelID"`
+	PropertyAddress string      `json:"PropertyAddress"`
+	TitleHolder     string      `json:"TitleHolder"`
+	Liens           []Lien      `json:"Liens"`
+	Valuations      []Valuation `json:"Valuations"`
+	ModifiedBy      string      `json:"ModifiedBy"`
+}
+
+//==============================================================================================================================
+//	Lien - A lien recorded against a property when a mortgage is disbursed and released when it is paid off.
+//==============================================================================================================================
+type Lien struct {
+	MortgageNumber int    `json:"MortgageNumber"`
+	LienHolder     string `json:"LienHolder"`
+	Amount         int    `json:"Amount"`
+	Status         string `json:"Status"`
+	RecordedTxID   string `json:"RecordedTxID"`
+	ReleasedTxID   string `json:"ReleasedTxID"`
+}
+
+//==============================================================================================================================
+//	Valuation - A valuation of a property at a point in time.
+//==============================================================================================================================
+type Valuation struct {
+	Value      int    `json:"Value"`
+	Date       string `json:"Date"`
+	Source     string `json:"Source"`
+	RecordedBy string `json:"RecordedBy"`
+}
+
+func property_key(parcelID string) string {
+	return "property_" + parcelID
+}
+
+func (t *SimpleChaincode) register_property(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var property Property
+
+	//Logging
+	fmt.Println("running register_property()")
+
+	// verify is the Json is sent.
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting one JSON object to register property")
+	}
+	err := json.Unmarshal([]byte(args[0]), &property)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling property json object")
+	}
+	if property.ParcelID == "" {
+		return nil, errors.New("ParcelID is required to register a property")
+	}
+
+	username, err := t.check_city_council(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := stub.GetState(property_key(property.ParcelID))
+	if err != nil {
+		return nil, errors.New("error while fetching property " + property.ParcelID)
+	}
+	if existing != nil {
+		return nil, errors.New("property " + property.ParcelID + " already registered")
+	}
+
+	//Liens are only recorded by the mortgage life cycle
+	property.Liens = nil
+	for i := range property.Valuations {
+		if property.Valuations[i].Value <= 0 {
+			return nil, errors.New("Valuation must be greater than zero")
+		}
+		property.Valuations[i].Date, err = t.validate_date(stub, property.Valuations[i].Date)
+		if err != nil {
+			return nil, err
+		}
+		property.Valuations[i].RecordedBy = username
+	}
+	property.ModifiedBy = username
+
+	return nil, t.save_property(stub, property)
+}
+
+func (t *SimpleChaincode) update_property(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var update Property
+
+	//Logging
+	fmt.Println("running update_property()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting one JSON object to update property")
+	}
+	err := json.Unmarshal([]byte(args[0]), &update)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling property json object")
+	}
+
+	username, err := t.check_city_council(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	property, err := t.get_property(stub, update.ParcelID)
+	if err != nil {
+		return nil, err
+	}
+	if update.PropertyAddress != "" {
+		property.PropertyAddress = update.PropertyAddress
+	}
+	if update.TitleHolder != "" {
+		property.TitleHolder = update.TitleHolder
+	}
+	property.ModifiedBy = username
+
+	return nil, t.save_property(stub, property)
+}
+
+func (t *SimpleChaincode) add_property_valuation(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var valuation Valuation
+
+	//Logging
+	fmt.Println("running add_property_valuation()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting parcel id and one JSON valuation object")
+	}
+	err := json.Unmarshal([]byte(args[1]), &valuation)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling valuation json object")
+	}
+	if valuation.Value <= 0 {
+		return nil, errors.New("Valuation must be greater than zero")
+	}
+	valuation.Date, err = t.validate_date(stub, valuation.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := t.check_city_council(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	property, err := t.get_property(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	valuation.RecordedBy = username
+	property.Valuations = append(property.Valuations, valuation)
+	property.ModifiedBy = username
+
+	return nil, t.save_property(stub, property)
+}
+
+func (t *SimpleChaincode) retrieve_property(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running retrieve_property()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting parcel id")
+	}
+
+	property, err := t.get_property(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(property)
+}
+
+//==============================================================================================================================
+//	update_property_lien - Keeps the lien of a mortgage on its property in line with the mortgage. A lien is recorded
+//			  when the mortgage is disbursed, follows the mortgage when it is sold and is released on payoff.
+//==============================================================================================================================
+func (t *SimpleChaincode) update_property_lien(stub shim.ChaincodeStubInterface, mortgage Mortgage) error {
+
+	// Mortgages created before the property registry have no property to record a lien on.
+	if mortgage.PropertyID == "" || !strings.Contains(strings.ToUpper(mortgage.MortgageStage), "DISBURSED:") {
+		return nil
+	}
+
+	property, err := t.get_mortgage_property(stub, mortgage)
+	if err != nil {
+		return err
+	}
+
+	active := -1
+	for i, lien := range property.Liens {
+		if lien.MortgageNumber == mortgage.MortgageNumber && lien.Status == LIEN_RECORDED {
+			active = i
+		}
+	}
+
+	switch {
+	case active < 0 && mortgage.RemainingMortgageAmount > 0:
+		property.Liens = append(property.Liens, Lien{
+			MortgageNumber: mortgage.MortgageNumber,
+			LienHolder:     mortgage.MortgagePropertyOwnership,
+			Amount:         mortgage.GrantedLoanAmount,
+			Status:         LIEN_RECORDED,
+			RecordedTxID:   stub.GetTxID(),
+		})
+	case active >= 0 && mortgage.RemainingMortgageAmount <= 0:
+		property.Liens[active].Status = LIEN_RELEASED
+		property.Liens[active].ReleasedTxID = stub.GetTxID()
+	case active >= 0 && property.Liens[active].LienHolder != mortgage.MortgagePropertyOwnership:
+		property.Liens[active].LienHolder = mortgage.MortgagePropertyOwnership
+	default:
+		return nil
+	}
+
+	return t.save_property(stub, property)
+}
+
+//==============================================================================================================================
+//	check_city_council - Only the city council maintains the property registry, returns the username of the caller.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_city_council(stub shim.ChaincodeStubInterface) (string, error) {
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return "", err
+	}
+	if role != CITY_COUNCIL {
+		return "", errors.New("Permission denied. Only the city council can maintain the property registry")
+	}
+	return username, nil
+}
+
+//==============================================================================================================================
+//	get_property - Retrieves a Property record from the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_property(stub shim.ChaincodeStubInterface, parcelID string) (Property, error) {
+	var property Property
+
+	if parcelID == "" {
+		return property, errors.New("PropertyID is required")
+	}
+
+	bytes, err := stub.GetState(property_key(parcelID))
+	if err != nil {
+		return property, errors.New("error while fetching property " + parcelID)
+	}
+	if bytes == nil {
+		return property, errors.New("property " + parcelID + " is not registered")
+	}
+
+	err = json.Unmarshal(bytes, &property)
+	if err != nil {
+		return property, errors.New("error while Unmarshalling property " + parcelID)
+	}
+	return property, nil
+}
+
+//==============================================================================================================================
+//	save_property - Writes a Property record to the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_property(stub shim.ChaincodeStubInterface, property Property) error {
+	bytes, err := json.Marshal(property)
+	if err != nil {
+		return errors.New("Error in Marshalling Property record")
+	}
+
+	err = stub.PutState(property_key(property.ParcelID), bytes)
+	if err != nil {
+		return errors.New("Error storing Property record in blockchain")
+	}
+	return nil
+}
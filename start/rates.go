@@ -0,0 +1,302 @@
+/*
+Dream Mortgage Chaincode - Rate Index and Adjustable Rate Mortgages
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	RateIndex - A base rate index published by the federal reserve, Rates are kept in order of EffectiveDate.
+//==============================================================================================================================
+type RateIndex struct {
+	IndexName string      `json:"IndexName"`
+	Rates     []IndexRate `json:"Rates"`
+}
+
+//==============================================================================================================================
+//	IndexRate - The value of a rate index from its EffectiveDate onwards.
+//==============================================================================================================================
+type IndexRate struct {
+	Rate          float32 `json:"Rate"`
+	EffectiveDate string  `json:"EffectiveDate"`
+	PublishedBy   string  `json:"PublishedBy"`
+}
+
+//==============================================================================================================================
+//	AdjustableRateTerms - Terms of an adjustable rate mortgage. The rate is the index rate plus Margin, moved by at most
+//			  PeriodicCap on each reset, never more than LifetimeCap above InitialRate and never below Floor.
+//			  A cap of zero means no cap.
+//==============================================================================================================================
+type AdjustableRateTerms struct {
+	IndexName         string  `json:"IndexName"`
+	Margin            float32 `json:"Margin"`
+	InitialRate       float32 `json:"InitialRate"`
+	PeriodicCap       float32 `json:"PeriodicCap"`
+	LifetimeCap       float32 `json:"LifetimeCap"`
+	Floor             float32 `json:"Floor"`
+	ResetPeriodMonths int     `json:"ResetPeriodMonths"`
+	NextResetDate     string  `json:"NextResetDate"`
+}
+
+func rate_index_key(indexName string) string {
+	return "rate_index_" + indexName
+}
+
+func (t *SimpleChaincode) publish_rate_index(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var rate IndexRate
+
+	//Logging
+	fmt.Println("running publish_rate_index()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting index name and one JSON rate object")
+	}
+	if args[0] == "" {
+		return nil, errors.New("Index name is required to publish a rate")
+	}
+	err := json.Unmarshal([]byte(args[1]), &rate)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling rate json object")
+	}
+	if rate.Rate < 0 {
+		return nil, errors.New("Rate can not be negative")
+	}
+	effective, err := parse_date(rate.EffectiveDate)
+	if err != nil {
+		return nil, err
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != FEDERAL_RESERVE {
+		return nil, errors.New("Permission denied. Only the federal reserve can publish rates")
+	}
+
+	index, err := t.get_rate_index(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	index.IndexName = args[0]
+	if len(index.Rates) > 0 {
+		last, _ := parse_date(index.Rates[len(index.Rates)-1].EffectiveDate)
+		if effective.Before(last) {
+			return nil, errors.New("Rate effective " + rate.EffectiveDate + " is older than the latest published rate")
+		}
+	}
+	rate.PublishedBy = username
+	index.Rates = append(index.Rates, rate)
+
+	bytes, err := json.Marshal(index)
+	if err != nil {
+		return nil, errors.New("Error in Marshalling Rate Index record")
+	}
+	err = stub.PutState(rate_index_key(index.IndexName), bytes)
+	if err != nil {
+		return nil, errors.New("Error storing Rate Index record in blockchain")
+	}
+	return nil, nil
+}
+
+func (t *SimpleChaincode) reset_rates(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running reset_rates()")
+
+	_, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != LENDING_BANK && role != FEDERAL_RESERVE {
+		return nil, errors.New("Permission denied. Only the lending bank or the federal reserve can reset rates")
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	mortgages, err := t.get_mortgage_portfolio(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, number := range mortgages.MortgageNumbers {
+		mortgage, err := t.get_mortgage(stub, number)
+		if err != nil {
+			return nil, err
+		}
+		if mortgage.AdjustableRate == nil {
+			continue
+		}
+
+		terms := mortgage.AdjustableRate
+		resetDate, err := parse_date(terms.NextResetDate)
+		if err != nil {
+			return nil, err
+		}
+		if resetDate.After(now) {
+			continue
+		}
+
+		// Terms are checked when the mortgage is created, a stored period that would never move the reset date on is
+		// refused rather than looped on.
+		if terms.ResetPeriodMonths <= 0 {
+			return nil, errors.New("ResetPeriodMonths of mortgage " + strconv.Itoa(number) + " must be greater than zero")
+		}
+
+		// A reset missed for several periods is priced once at today's index.
+		for !resetDate.After(now) {
+			resetDate = resetDate.AddDate(0, terms.ResetPeriodMonths, 0)
+		}
+		terms.NextResetDate = resetDate.Format("2006-01-02")
+
+		indexRate, err := t.get_index_rate(stub, terms.IndexName, now)
+		if err != nil {
+			return nil, err
+		}
+
+		// Interest up to the reset is accrued at the old rate.
+		err = accrue_mortgage_interest(&mortgage, now)
+		if err != nil {
+			return nil, err
+		}
+		mortgage.RateofInterest = adjusted_rate(terms, mortgage.RateofInterest, indexRate)
+
+		err = t.update_risk_profile(stub, &mortgage)
+		if err != nil {
+			return nil, err
+		}
+		err = t.save_mortgage(stub, mortgage)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (t *SimpleChaincode) retrieve_rate_index(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running retrieve_rate_index()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting index name")
+	}
+
+	index, err := t.get_rate_index(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if index.IndexName == "" {
+		return nil, errors.New("rate index " + args[0] + " does not exist")
+	}
+	return json.Marshal(index)
+}
+
+//==============================================================================================================================
+//	validate_adjustable_rate - Checks the adjustable rate terms of a new mortgage and sets its initial rate.
+//==============================================================================================================================
+func (t *SimpleChaincode) validate_adjustable_rate(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	terms := mortgage.AdjustableRate
+
+	if terms.ResetPeriodMonths <= 0 {
+		return errors.New("ResetPeriodMonths must be greater than zero for an adjustable rate mortgage")
+	}
+	if terms.PeriodicCap < 0 || terms.LifetimeCap < 0 || terms.Floor < 0 {
+		return errors.New("Rate caps and floor can not be negative")
+	}
+	_, err := parse_date(terms.NextResetDate)
+	if err != nil {
+		return err
+	}
+
+	now, err := t.get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+	indexRate, err := t.get_index_rate(stub, terms.IndexName, now)
+	if err != nil {
+		return err
+	}
+
+	if terms.InitialRate <= 0 {
+		terms.InitialRate = indexRate + terms.Margin
+	}
+	mortgage.RateofInterest = terms.InitialRate
+	return nil
+}
+
+//==============================================================================================================================
+//	adjusted_rate - Applies the caps and floor of an adjustable rate mortgage to its new index based rate.
+//==============================================================================================================================
+func adjusted_rate(terms *AdjustableRateTerms, currentRate float32, indexRate float32) float32 {
+	rate := indexRate + terms.Margin
+
+	if terms.PeriodicCap > 0 {
+		if rate > currentRate+terms.PeriodicCap {
+			rate = currentRate + terms.PeriodicCap
+		} else if rate < currentRate-terms.PeriodicCap {
+			rate = currentRate - terms.PeriodicCap
+		}
+	}
+	if terms.LifetimeCap > 0 && rate > terms.InitialRate+terms.LifetimeCap {
+		rate = terms.InitialRate + terms.LifetimeCap
+	}
+	if rate < terms.Floor {
+		rate = terms.Floor
+	}
+	if rate < 0 {
+		rate = 0
+	}
+	return rate
+}
+
+//==============================================================================================================================
+//	get_index_rate - Returns the rate of an index in effect at the given time.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_index_rate(stub shim.ChaincodeStubInterface, indexName string, at time.Time) (float32, error) {
+	index, err := t.get_rate_index(stub, indexName)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := len(index.Rates) - 1; i >= 0; i-- {
+		effective, err := parse_date(index.Rates[i].EffectiveDate)
+		if err == nil && !effective.After(at) {
+			return index.Rates[i].Rate, nil
+		}
+	}
+	return 0, errors.New("no rate of index " + indexName + " is in effect")
+}
+
+//==============================================================================================================================
+//	get_rate_index - Retrieves a RateIndex record from the blockchain, an index that was never published is empty.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_rate_index(stub shim.ChaincodeStubInterface, indexName string) (RateIndex, error) {
+	var index RateIndex
+
+	bytes, err := stub.GetState(rate_index_key(indexName))
+	if err != nil {
+		return index, errors.New("error while fetching rate index " + indexName)
+	}
+	if bytes == nil {
+		return index, nil
+	}
+
+	err = json.Unmarshal(bytes, &index)
+	if err != nil {
+		return index, errors.New("error while Unmarshalling rate index " + indexName)
+	}
+	return index, nil
+}
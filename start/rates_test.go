@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAdjustedRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		terms     AdjustableRateTerms
+		current   float32
+		indexRate float32
+		want      float32
+	}{
+		{name: "no caps", terms: AdjustableRateTerms{Margin: 2}, current: 4, indexRate: 5, want: 7},
+		{name: "periodic cap up", terms: AdjustableRateTerms{Margin: 2, PeriodicCap: 1}, current: 4, indexRate: 5, want: 5},
+		{name: "periodic cap down", terms: AdjustableRateTerms{Margin: 2, PeriodicCap: 1}, current: 6, indexRate: 1, want: 5},
+		{name: "lifetime cap", terms: AdjustableRateTerms{Margin: 2, InitialRate: 3, LifetimeCap: 2}, current: 4, indexRate: 5, want: 5},
+		{name: "floor", terms: AdjustableRateTerms{Margin: 1, Floor: 3}, current: 4, indexRate: 1, want: 3},
+		{name: "never negative", terms: AdjustableRateTerms{Margin: -2}, current: 1, indexRate: 1, want: 0},
+	}
+	for _, test := range tests {
+		if got := adjusted_rate(&test.terms, test.current, test.indexRate); got != test.want {
+			t.Errorf("%s: adjusted_rate = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestResetRates(t *testing.T) {
+	tests := []struct {
+		name          string
+		resetPeriod   int
+		nextReset     string
+		wantRate      float32
+		wantNextReset string
+		refused       bool
+	}{
+		{name: "reset due", resetPeriod: 12, nextReset: "2023-06-01", wantRate: 6, wantNextReset: "2024-06-01"},
+		{name: "missed resets", resetPeriod: 6, nextReset: "2022-01-01", wantRate: 6, wantNextReset: "2024-01-01"},
+		{name: "reset not due", resetPeriod: 12, nextReset: "2024-06-01", wantRate: 4, wantNextReset: "2024-06-01"},
+		{name: "no reset period", resetPeriod: 0, nextReset: "2023-06-01", refused: true},
+	}
+	for _, test := range tests {
+		stub := new_test_stub(t)
+		stub.State[rate_index_key("PRIME")] = []byte(`{"IndexName":"PRIME","Rates":[{"Rate":5,"EffectiveDate":"2023-01-01"}]}`)
+		stub.State["mortgages"] = []byte(`{"MortgageNumbers":[1000001],"CustomerIDs":["bob"],"MortgageStages":["Pending-Bank:"],"ConformedMortgages":[false],"MortgagePropertyOwnerships":["NOT_ACCQUIRED"]}`)
+		terms, _ := json.Marshal(AdjustableRateTerms{IndexName: "PRIME", Margin: 1, InitialRate: 4, ResetPeriodMonths: test.resetPeriod, NextResetDate: test.nextReset})
+		stub.State[string(rune(1000001))] = []byte(`{"MortgageNumber":1000001,"SchemaVersion":2,"CustomerID":"bob","MortgageStage":"Pending-Bank:","RateofInterest":4,"DayCountConvention":"ACT/365","MortgagePropertyOwnership":"NOT_ACCQUIRED","AdjustableRate":` + string(terms) + `}`)
+		stub.State[customer_key("bob")] = []byte(`{"CustomerID":"bob","MortgageNumbers":[1000001]}`)
+
+		stub.caller("fed", FEDERAL_RESERVE)
+		_, err := new(SimpleChaincode).Invoke(stub, "reset_rates", nil)
+		if test.refused {
+			if err == nil {
+				t.Errorf("%s: reset_rates succeeded, want it refused", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		mortgage, _ := new(SimpleChaincode).get_mortgage(stub, 1000001)
+		if mortgage.RateofInterest != test.wantRate || mortgage.AdjustableRate.NextResetDate != test.wantNextReset {
+			t.Errorf("%s: rate %v next reset %s, want %v and %s", test.name, mortgage.RateofInterest, mortgage.AdjustableRate.NextResetDate, test.wantRate, test.wantNextReset)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+/*
+Dream Mortgage Chaincode - Mortgage Schema Versions and Migration
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	MORTGAGE_SCHEMA_VERSION - The version of the Mortgage struct. Records stored before versioning are version 1.
+//==============================================================================================================================
+const MORTGAGE_SCHEMA_VERSION = 2
+
+//==============================================================================================================================
+//	MIGRATION_BATCH_SIZE - Mortgages migrated by one migrate_mortgages transaction unless a batch size is given.
+//==============================================================================================================================
+const MIGRATION_BATCH_SIZE = 50
+
+//==============================================================================================================================
+//	mortgage_upgrades - Upgrade of a stored mortgage from the version it is registered under to the next version. The
+//			  upgrades work on the raw JSON so fields that were renamed or retyped can still be read.
+//==============================================================================================================================
+var mortgage_upgrades = map[int]func(record map[string]interface{}) error{
+	1: upgrade_mortgage_v1,
+}
+
+//==============================================================================================================================
+//	legacy_fields - Customer and property fields stored on version 1 mortgages, by the LegacyParties field they are
+//			  kept under until the upgraded mortgage is saved.
+//==============================================================================================================================
+var legacy_fields = map[string]string{
+	"CustomerName":            "CustomerName",
+	"CustomerAddress":         "CustomerAddress",
+	"CustomerSSN":             "CustomerSSN",
+	"CustomerDOB":             "CustomerDOB",
+	"MortgagePropertyAddress": "PropertyAddress",
+}
+
+//==============================================================================================================================
+//	LegacyParties - The customer and property of a mortgage stored before customers and properties had records of
+//			  their own. They are moved to those records when the upgraded mortgage is saved.
+//==============================================================================================================================
+type LegacyParties struct {
+	CustomerName    string `json:"CustomerName"`
+	CustomerAddress string `json:"CustomerAddress"`
+	CustomerSSN     int    `json:"CustomerSSN"`
+	CustomerDOB     string `json:"CustomerDOB"`
+	PropertyAddress string `json:"PropertyAddress"`
+}
+
+//==============================================================================================================================
+//	MigrationProgress - Progress of the batch migration of the mortgage portfolio to TargetVersion. Cursor is the
+//			  index in the portfolio of the next mortgage to migrate.
+//==============================================================================================================================
+type MigrationProgress struct {
+	TargetVersion int    `json:"TargetVersion"`
+	Cursor        int    `json:"Cursor"`
+	Total         int    `json:"Total"`
+	Migrated      int    `json:"Migrated"`
+	Completed     bool   `json:"Completed"`
+	UpdatedBy     string `json:"UpdatedBy"`
+}
+
+func (t *SimpleChaincode) migrate_mortgages(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+
+	//Logging
+	fmt.Println("running migrate_mortgages()")
+
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting an optional batch size")
+	}
+	batchSize := MIGRATION_BATCH_SIZE
+	if len(args) == 1 {
+		batchSize, err = strconv.Atoi(args[0])
+		if err != nil || batchSize <= 0 {
+			return nil, errors.New("Invalid batch size " + args[0])
+		}
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != ADMIN {
+		return nil, errors.New("Permission denied. Only the admin can migrate mortgages")
+	}
+
+	progress, err := t.get_migration_progress(stub)
+	if err != nil {
+		return nil, err
+	}
+	// A migration to a new version starts again from the beginning of the portfolio.
+	if progress.TargetVersion != MORTGAGE_SCHEMA_VERSION {
+		progress = MigrationProgress{TargetVersion: MORTGAGE_SCHEMA_VERSION}
+	}
+
+	// The portfolio is upgraded when it is read, storing it keeps the upgrade.
+	mortgages, err := t.get_mortgage_portfolio(stub)
+	if err != nil {
+		return nil, err
+	}
+	portfoliobytes, err := json.Marshal(mortgages)
+	if err != nil {
+		return nil, errors.New("Error in Marshalling Mortgage Portfolio record")
+	}
+	err = stub.PutState("mortgages", portfoliobytes)
+	if err != nil {
+		return nil, errors.New("Error storing Mortgage Portfolio record in blockchain")
+	}
+	progress.Total = len(mortgages.MortgageNumbers)
+
+	end := progress.Cursor + batchSize
+	if end > progress.Total {
+		end = progress.Total
+	}
+	for _, number := range mortgages.MortgageNumbers[progress.Cursor:end] {
+		mortgagebytes, err := stub.GetState(string(rune(number)))
+		if err != nil {
+			return nil, errors.New("error while fetching mortgage number " + strconv.Itoa(number))
+		}
+		version, err := mortgage_schema_version(mortgagebytes)
+		if err != nil {
+			return nil, errors.New("error while Unmarshalling mortgage number " + strconv.Itoa(number))
+		}
+		if version >= MORTGAGE_SCHEMA_VERSION {
+			continue
+		}
+
+		mortgage, err := t.get_mortgage(stub, number)
+		if err != nil {
+			return nil, err
+		}
+		err = t.save_mortgage(stub, mortgage)
+		if err != nil {
+			return nil, err
+		}
+		progress.Migrated++
+	}
+
+	progress.Cursor = end
+	progress.Completed = progress.Cursor >= progress.Total
+	progress.UpdatedBy = username
+
+	bytes, err := json.Marshal(progress)
+	if err != nil {
+		return nil, errors.New("Error in Marshalling Migration Progress record")
+	}
+	err = stub.PutState("mortgage_migration", bytes)
+	if err != nil {
+		return nil, errors.New("Error storing Migration Progress record in blockchain")
+	}
+	return bytes, nil
+}
+
+func (t *SimpleChaincode) retrieve_migration_status(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running retrieve_migration_status()")
+
+	_, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != ADMIN && role != AUDITOR {
+		return nil, errors.New("Permission denied. Only the admin or the auditor can retrieve the migration status")
+	}
+
+	progress, err := t.get_migration_progress(stub)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(progress)
+}
+
+//==============================================================================================================================
+//	upgrade_mortgage - Reads a stored mortgage, applying the upgrades from its schema version to the current version.
+//			  The upgraded mortgage is only written back when it is next saved.
+//==============================================================================================================================
+func upgrade_mortgage(mortgagebytes []byte) (Mortgage, error) {
+	var mortgage Mortgage
+	var record map[string]interface{}
+
+	err := json.Unmarshal(mortgagebytes, &record)
+	if err != nil {
+		return mortgage, err
+	}
+
+	version, err := mortgage_schema_version(mortgagebytes)
+	if err != nil {
+		return mortgage, err
+	}
+	if version > MORTGAGE_SCHEMA_VERSION {
+		return mortgage, errors.New("mortgage schema version " + strconv.Itoa(version) + " is newer than this chaincode")
+	}
+
+	if version < MORTGAGE_SCHEMA_VERSION {
+		for ; version < MORTGAGE_SCHEMA_VERSION; version++ {
+			upgrade, found := mortgage_upgrades[version]
+			if !found {
+				return mortgage, errors.New("no upgrade registered for mortgage schema version " + strconv.Itoa(version))
+			}
+			err = upgrade(record)
+			if err != nil {
+				return mortgage, err
+			}
+		}
+		record["SchemaVersion"] = MORTGAGE_SCHEMA_VERSION
+
+		mortgagebytes, err = json.Marshal(record)
+		if err != nil {
+			return mortgage, err
+		}
+	}
+
+	err = json.Unmarshal(mortgagebytes, &mortgage)
+	if err != nil {
+		return mortgage, err
+	}
+
+	// Legacy parties are not part of the mortgage, they are only kept until the upgraded mortgage is saved.
+	var stash struct {
+		Legacy *LegacyParties `json:"Legacy"`
+	}
+	err = json.Unmarshal(mortgagebytes, &stash)
+	mortgage.Legacy = stash.Legacy
+	return mortgage, err
+}
+
+//==============================================================================================================================
+//	upgrade_portfolio - Portfolios stored before customers had ids list the customer of each mortgage under
+//			  CustomerNames, those mortgages get the legacy customer id of their number. Lists of the portfolio
+//			  shorter than MortgageNumbers are extended to its length.
+//==============================================================================================================================
+func upgrade_portfolio(mortgages *mortgage_portfolio) {
+	if len(mortgages.CustomerNames) > 0 && len(mortgages.CustomerIDs) < len(mortgages.MortgageNumbers) {
+		// Mortgages created since customers had ids appended their CustomerID after the names.
+		var customerIDs []string
+		for i := range mortgages.CustomerNames {
+			if i < len(mortgages.MortgageNumbers) {
+				customerIDs = append(customerIDs, legacy_customer_id(mortgages.MortgageNumbers[i]))
+			}
+		}
+		mortgages.CustomerIDs = append(customerIDs, mortgages.CustomerIDs...)
+	}
+	mortgages.CustomerNames = nil
+
+	for len(mortgages.CustomerIDs) < len(mortgages.MortgageNumbers) {
+		mortgages.CustomerIDs = append(mortgages.CustomerIDs, "")
+	}
+	for len(mortgages.MortgageStages) < len(mortgages.MortgageNumbers) {
+		mortgages.MortgageStages = append(mortgages.MortgageStages, "")
+	}
+	for len(mortgages.ConformedMortgages) < len(mortgages.MortgageNumbers) {
+		mortgages.ConformedMortgages = append(mortgages.ConformedMortgages, false)
+	}
+	for len(mortgages.MortgagePropertyOwnerships) < len(mortgages.MortgageNumbers) {
+		mortgages.MortgagePropertyOwnerships = append(mortgages.MortgagePropertyOwnerships, "")
+	}
+}
+
+//==============================================================================================================================
+//	mortgage_schema_version - The schema version of a stored mortgage, version 1 if it was stored without one.
+//==============================================================================================================================
+func mortgage_schema_version(mortgagebytes []byte) (int, error) {
+	var header struct {
+		SchemaVersion int `json:"SchemaVersion"`
+	}
+
+	err := json.Unmarshal(mortgagebytes, &header)
+	if err != nil {
+		return 0, err
+	}
+	if header.SchemaVersion == 0 {
+		return 1, nil
+	}
+	return header.SchemaVersion, nil
+}
+
+//==============================================================================================================================
+//	upgrade_mortgage_v1 - Version 2 requires a day count convention and an ownership on every mortgage, and links the
+//			  mortgage to a customer and a property instead of storing them on the mortgage. A mortgage without a
+//			  CustomerID gets a customer of its own, names are free text and do not identify a customer. The
+//			  property is identified by its address.
+//==============================================================================================================================
+func upgrade_mortgage_v1(record map[string]interface{}) error {
+	if convention, _ := record["DayCountConvention"].(string); convention == "" {
+		record["DayCountConvention"] = DAY_COUNT_ACT_365
+	}
+	if ownership, _ := record["MortgagePropertyOwnership"].(string); ownership == "" {
+		record["MortgagePropertyOwnership"] = "NOT_ACCQUIRED"
+	}
+
+	legacy := map[string]interface{}{}
+	for field, legacyField := range legacy_fields {
+		if value, found := record[field]; found {
+			legacy[legacyField] = value
+			delete(record, field)
+		}
+	}
+	if customerID, _ := record["CustomerID"].(string); customerID == "" {
+		number, _ := record["MortgageNumber"].(float64)
+		record["CustomerID"] = legacy_customer_id(int(number))
+	} else if len(legacy) == 0 {
+		return nil
+	}
+	record["Legacy"] = legacy
+
+	if propertyID, _ := record["PropertyID"].(string); propertyID == "" {
+		if address, _ := legacy["PropertyAddress"].(string); address != "" {
+			record["PropertyID"] = legacy_parcel_id(address)
+		}
+	}
+	return nil
+}
+
+func legacy_customer_id(mortgageNumber int) string {
+	return "legacy-customer-" + strconv.Itoa(mortgageNumber)
+}
+
+func legacy_parcel_id(address string) string {
+	return "legacy-" + address
+}
+
+//==============================================================================================================================
+//	link_legacy_parties - Creates the customer and property of a mortgage upgraded from version 1 from the details
+//			  stored on it, or links the mortgage to the records when they already exist.
+//==============================================================================================================================
+func (t *SimpleChaincode) link_legacy_parties(stub shim.ChaincodeStubInterface, mortgage Mortgage) error {
+	customer, err := t.get_mortgage_customer(stub, mortgage)
+	if err != nil {
+		return err
+	}
+	linked := false
+	for _, number := range customer.MortgageNumbers {
+		linked = linked || number == mortgage.MortgageNumber
+	}
+	if !linked {
+		customer.MortgageNumbers = append(customer.MortgageNumbers, mortgage.MortgageNumber)
+		err = t.save_customer(stub, customer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if mortgage.PropertyID == "" {
+		return nil
+	}
+	property, err := t.get_mortgage_property(stub, mortgage)
+	if err != nil {
+		return err
+	}
+	return t.save_property(stub, property)
+}
+
+//==============================================================================================================================
+//	get_mortgage_customer - Retrieves the customer of a mortgage. The customer of a mortgage upgraded from version 1 only
+//			  has a record once the mortgage is saved, until then it is taken from the details stored on the mortgage.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_mortgage_customer(stub shim.ChaincodeStubInterface, mortgage Mortgage) (Customer, error) {
+	if mortgage.Legacy != nil {
+		bytes, err := stub.GetState(customer_key(mortgage.CustomerID))
+		if err != nil {
+			return Customer{}, errors.New("error while fetching customer " + mortgage.CustomerID)
+		}
+		if bytes == nil {
+			return Customer{
+				CustomerID:      mortgage.CustomerID,
+				CustomerName:    mortgage.Legacy.CustomerName,
+				CustomerAddress: mortgage.Legacy.CustomerAddress,
+				CustomerSSN:     mortgage.Legacy.CustomerSSN,
+				CustomerDOB:     mortgage.Legacy.CustomerDOB,
+				KYCStatus:       KYC_PENDING,
+			}, nil
+		}
+	}
+	return t.get_customer(stub, mortgage.CustomerID)
+}
+
+//==============================================================================================================================
+//	get_mortgage_property - Retrieves the property of a mortgage, like get_mortgage_customer the property of a mortgage
+//			  upgraded from version 1 is taken from the address stored on the mortgage until the mortgage is saved.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_mortgage_property(stub shim.ChaincodeStubInterface, mortgage Mortgage) (Property, error) {
+	if mortgage.Legacy != nil && mortgage.PropertyID != "" {
+		bytes, err := stub.GetState(property_key(mortgage.PropertyID))
+		if err != nil {
+			return Property{}, errors.New("error while fetching property " + mortgage.PropertyID)
+		}
+		if bytes == nil {
+			return Property{ParcelID: mortgage.PropertyID, PropertyAddress: mortgage.Legacy.PropertyAddress}, nil
+		}
+	}
+	return t.get_property(stub, mortgage.PropertyID)
+}
+
+//==============================================================================================================================
+//	get_migration_progress - Retrieves the MigrationProgress record from the blockchain, empty before the first batch.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_migration_progress(stub shim.ChaincodeStubInterface) (MigrationProgress, error) {
+	var progress MigrationProgress
+
+	bytes, err := stub.GetState("mortgage_migration")
+	if err != nil {
+		return progress, errors.New("error while fetching migration progress")
+	}
+	if bytes == nil {
+		return progress, nil
+	}
+
+	err = json.Unmarshal(bytes, &progress)
+	if err != nil {
+		return progress, errors.New("error while Unmarshalling migration progress")
+	}
+	return progress, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestUpgradeMortgageV1(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored string
+		want   string
+	}{
+		{
+			name:   "legacy borrower and property",
+			stored: `{"MortgageNumber":7,"CustomerName":"Old Joe","CustomerSSN":1,"MortgagePropertyAddress":"9 Oak"}`,
+			want:   `{"MortgageNumber":7,"CustomerID":"legacy-customer-7","PropertyID":"legacy-9 Oak","DayCountConvention":"ACT/365","MortgagePropertyOwnership":"NOT_ACCQUIRED","Legacy":{"CustomerName":"Old Joe","CustomerSSN":1,"PropertyAddress":"9 Oak"}}`,
+		},
+		{
+			name:   "borrowers with the same name are not merged",
+			stored: `{"MortgageNumber":8,"CustomerName":"Old Joe"}`,
+			want:   `{"MortgageNumber":8,"CustomerID":"legacy-customer-8","DayCountConvention":"ACT/365","MortgagePropertyOwnership":"NOT_ACCQUIRED","Legacy":{"CustomerName":"Old Joe"}}`,
+		},
+		{
+			name:   "no customer details",
+			stored: `{"MortgageNumber":9}`,
+			want:   `{"MortgageNumber":9,"CustomerID":"legacy-customer-9","DayCountConvention":"ACT/365","MortgagePropertyOwnership":"NOT_ACCQUIRED","Legacy":{}}`,
+		},
+		{
+			name:   "already linked",
+			stored: `{"MortgageNumber":10,"CustomerID":"bob","PropertyID":"P1","DayCountConvention":"30/360","MortgagePropertyOwnership":"GSE"}`,
+			want:   `{"MortgageNumber":10,"CustomerID":"bob","PropertyID":"P1","DayCountConvention":"30/360","MortgagePropertyOwnership":"GSE"}`,
+		},
+	}
+
+	for _, test := range tests {
+		var record, want map[string]interface{}
+		json.Unmarshal([]byte(test.stored), &record)
+		json.Unmarshal([]byte(test.want), &want)
+
+		err := upgrade_mortgage_v1(record)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(record, want) {
+			t.Errorf("%s: upgraded to %v, want %v", test.name, record, want)
+		}
+	}
+}
+
+func TestUpgradePortfolio(t *testing.T) {
+	tests := []struct {
+		name      string
+		portfolio mortgage_portfolio
+		want      []string
+	}{
+		{
+			name:      "names only",
+			portfolio: mortgage_portfolio{MortgageNumbers: []int{1, 2}, CustomerNames: []string{"Old Joe", "Old Joe"}},
+			want:      []string{"legacy-customer-1", "legacy-customer-2"},
+		},
+		{
+			name:      "ids appended after the names",
+			portfolio: mortgage_portfolio{MortgageNumbers: []int{1, 2, 3}, CustomerNames: []string{"Old Joe"}, CustomerIDs: []string{"bob", "ann"}},
+			want:      []string{"legacy-customer-1", "bob", "ann"},
+		},
+		{
+			name:      "already upgraded",
+			portfolio: mortgage_portfolio{MortgageNumbers: []int{1, 2}, CustomerIDs: []string{"bob", "ann"}},
+			want:      []string{"bob", "ann"},
+		},
+		{
+			name:      "shorter list extended",
+			portfolio: mortgage_portfolio{MortgageNumbers: []int{1, 2}, CustomerIDs: []string{"bob"}},
+			want:      []string{"bob", ""},
+		},
+	}
+
+	for _, test := range tests {
+		portfolio := test.portfolio
+		upgrade_portfolio(&portfolio)
+
+		if !reflect.DeepEqual(portfolio.CustomerIDs, test.want) || portfolio.CustomerNames != nil {
+			t.Errorf("%s: CustomerIDs = %q, CustomerNames = %q, want %q", test.name, portfolio.CustomerIDs, portfolio.CustomerNames, test.want)
+		}
+		if len(portfolio.MortgageStages) != len(portfolio.MortgageNumbers) || len(portfolio.ConformedMortgages) != len(portfolio.MortgageNumbers) || len(portfolio.MortgagePropertyOwnerships) != len(portfolio.MortgageNumbers) {
+			t.Errorf("%s: portfolio lists not extended, %+v", test.name, portfolio)
+		}
+	}
+}
+
+func TestModifyMigratedMortgage(t *testing.T) {
+	stub := new_test_stub(t)
+	stub.State["mortgages"] = []byte(`{"MortgageNumbers":[1000001],"CustomerNames":["Old Joe"],"MortgageStages":["Disbursed:"],"ConformedMortgages":[false],"MortgagePropertyOwnerships":["LENDING_BANK"]}`)
+	stub.State[string(rune(1000001))] = []byte(`{"MortgageNumber":1000001,"CustomerName":"Old Joe","CustomerSSN":1,"MortgagePropertyAddress":"9 Oak","MortgageStage":"Disbursed:","MortgagePropertyOwnership":"LENDING_BANK","GrantedLoanAmount":1000,"RemainingMortgageAmount":1000,"RateofInterest":5,"MortgageDuration":3600}`)
+
+	stub.caller("bank", LENDING_BANK)
+	test_invoke(t, stub, "assess_fee", "1000001", `{"Amount":10,"Reason":"late"}`)
+	test_invoke(t, stub, "modify_mortgage", `{"MortgageNumber":1000001,"LastPaymentAmount":100}`)
+	test_invoke(t, stub, "accrue_interest", "1000001")
+
+	mortgage, err := new(SimpleChaincode).get_mortgage(stub, 1000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mortgage.Legacy != nil || len(mortgage.Payments) != 1 {
+		t.Errorf("mortgage = %+v, want upgraded with one payment", mortgage)
+	}
+
+	customer, err := new(SimpleChaincode).get_customer(stub, mortgage.CustomerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customer.CustomerName != "Old Joe" || len(customer.MortgageNumbers) != 1 {
+		t.Errorf("customer = %+v, want Old Joe with one mortgage", customer)
+	}
+
+	property, err := new(SimpleChaincode).get_property(stub, mortgage.PropertyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if property.PropertyAddress != "9 Oak" || len(property.Liens) != 1 {
+		t.Errorf("property = %+v, want 9 Oak with one lien", property)
+	}
+}
+
+func TestApproveMigratedApplication(t *testing.T) {
+	stub := new_test_stub(t)
+	stub.State["mortgages"] = []byte(`{"MortgageNumbers":[1000001],"CustomerNames":["Old Ann"],"MortgageStages":["Pending-Bank:"],"ConformedMortgages":[false]}`)
+	stub.State[string(rune(1000001))] = []byte(`{"MortgageNumber":1000001,"CustomerName":"Old Ann","MortgagePropertyAddress":"7 Elm","MortgageStage":"Pending-Bank:","ReqLoanAmount":9}`)
+
+	stub.caller("bank", LENDING_BANK)
+	test_invoke(t, stub, "open_underwriting", "1000001")
+	test_invoke(t, stub, "decide_underwriting", "1000001", `{"Status":"APPROVED"}`)
+	test_invoke(t, stub, "modify_mortgage", `{"MortgageNumber":1000001,"Ownershipcost":9}`)
+
+	mortgage, err := new(SimpleChaincode).get_mortgage(stub, 1000001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mortgage.MortgageStage != "Disbursed:" {
+		t.Errorf("MortgageStage = %q, want Disbursed:", mortgage.MortgageStage)
+	}
+}
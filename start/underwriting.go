@@ -0,0 +1,457 @@
+/*
+Dream Mortgage Chaincode - Underwriting Decisions
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	UNDERWRITING DECISIONS
+//==============================================================================================================================
+const UNDERWRITING_OPEN = "OPEN"
+const UNDERWRITING_APPROVED = "APPROVED"
+const UNDERWRITING_CONDITIONALLY_APPROVED = "CONDITIONALLY_APPROVED"
+const UNDERWRITING_DENIED = "DENIED"
+
+//==============================================================================================================================
+//	UNDERWRITING CONDITIONS
+//==============================================================================================================================
+const CONDITION_DOCUMENT = "DOCUMENT"
+const CONDITION_RATE_LOCK = "RATE_LOCK"
+const CONDITION_MAX_AMOUNT = "MAX_AMOUNT"
+
+//==============================================================================================================================
+//	UnderwritingDecision - The lending decision on a mortgage application. Conditions are recorded while the decision
+//			  is open, a conditional approval becomes an approval once all document conditions are satisfied.
+//==============================================================================================================================
+type UnderwritingDecision struct {
+	MortgageNumber      int                     `json:"MortgageNumber"`
+	Status              string                  `json:"Status"`
+	Conditions          []UnderwritingCondition `json:"Conditions"`
+	ReasonCode          string                  `json:"ReasonCode"`
+	AdverseActionReason string                  `json:"AdverseActionReason"`
+	OpenedBy            string                  `json:"OpenedBy"`
+	DecidedBy           string                  `json:"DecidedBy"`
+}
+
+//==============================================================================================================================
+//	UnderwritingCondition - A DOCUMENT condition requires a document of DocumentType, a RATE_LOCK condition locks the
+//			  rate of the mortgage at Rate and a MAX_AMOUNT condition caps the granted loan amount at Amount.
+//==============================================================================================================================
+type UnderwritingCondition struct {
+	ConditionID  int     `json:"ConditionID"`
+	Type         string  `json:"Type"`
+	Description  string  `json:"Description"`
+	DocumentType string  `json:"DocumentType"`
+	Rate         float32 `json:"Rate"`
+	Amount       int     `json:"Amount"`
+	Satisfied    bool    `json:"Satisfied"`
+	SatisfiedBy  string  `json:"SatisfiedBy"`
+}
+
+//==============================================================================================================================
+//	AdverseAction - Returned by retrieve_adverse_action, the reason a mortgage application was denied.
+//==============================================================================================================================
+type AdverseAction struct {
+	MortgageNumber      int    `json:"MortgageNumber"`
+	ReasonCode          string `json:"ReasonCode"`
+	AdverseActionReason string `json:"AdverseActionReason"`
+	DecidedBy           string `json:"DecidedBy"`
+}
+
+func underwriting_key(mortgageNumber int) string {
+	return "underwriting_" + strconv.Itoa(mortgageNumber)
+}
+
+func (t *SimpleChaincode) open_underwriting(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running open_underwriting()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number")
+	}
+	username, mortgage, err := t.get_underwriting_mortgage(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToUpper(mortgage.MortgageStage) != "PENDING-BANK:" {
+		return nil, errors.New("Mortgage " + args[0] + " is " + mortgage.MortgageStage + " and can not be underwritten")
+	}
+
+	decision := UnderwritingDecision{
+		MortgageNumber: mortgage.MortgageNumber,
+		Status:         UNDERWRITING_OPEN,
+		OpenedBy:       username,
+	}
+	err = t.save_underwriting(stub, decision)
+	if err != nil {
+		return nil, err
+	}
+
+	mortgage.MortgageStage = "Underwriting:"
+	return nil, t.save_mortgage(stub, mortgage)
+}
+
+func (t *SimpleChaincode) add_underwriting_condition(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var condition UnderwritingCondition
+
+	//Logging
+	fmt.Println("running add_underwriting_condition()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and one JSON condition")
+	}
+	err := json.Unmarshal([]byte(args[1]), &condition)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling condition json object")
+	}
+
+	switch condition.Type {
+	case CONDITION_DOCUMENT:
+		if !document_types[condition.DocumentType] {
+			return nil, errors.New("Invalid document type " + condition.DocumentType + " for a DOCUMENT condition")
+		}
+		condition.Satisfied = false
+	case CONDITION_RATE_LOCK:
+		if condition.Rate <= 0 {
+			return nil, errors.New("Rate must be greater than zero for a RATE_LOCK condition")
+		}
+		condition.Satisfied = true
+	case CONDITION_MAX_AMOUNT:
+		if condition.Amount <= 0 {
+			return nil, errors.New("Amount must be greater than zero for a MAX_AMOUNT condition")
+		}
+		condition.Satisfied = true
+	default:
+		return nil, errors.New("Invalid condition type " + condition.Type)
+	}
+
+	_, mortgage, err := t.get_underwriting_mortgage(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	decision, err := t.get_underwriting(stub, mortgage.MortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	if decision.Status != UNDERWRITING_OPEN {
+		return nil, errors.New("Underwriting of mortgage " + args[0] + " is " + decision.Status + " and can not take new conditions")
+	}
+
+	condition.ConditionID = len(decision.Conditions) + 1
+	condition.SatisfiedBy = ""
+	decision.Conditions = append(decision.Conditions, condition)
+
+	return nil, t.save_underwriting(stub, decision)
+}
+
+func (t *SimpleChaincode) satisfy_underwriting_condition(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running satisfy_underwriting_condition()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and condition id")
+	}
+	conditionID, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, errors.New("Invalid condition id " + args[1])
+	}
+
+	username, mortgage, err := t.get_underwriting_mortgage(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	decision, err := t.get_underwriting(stub, mortgage.MortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	if conditionID < 1 || conditionID > len(decision.Conditions) {
+		return nil, errors.New("Condition " + args[1] + " does not exist on mortgage " + args[0])
+	}
+
+	return nil, t.satisfy_condition(stub, decision, mortgage, conditionID-1, username)
+}
+
+func (t *SimpleChaincode) decide_underwriting(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var outcome UnderwritingDecision
+
+	//Logging
+	fmt.Println("running decide_underwriting()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number and one JSON decision")
+	}
+	err := json.Unmarshal([]byte(args[1]), &outcome)
+	if err != nil {
+		return nil, errors.New("error while Unmarshalling decision json object")
+	}
+
+	username, mortgage, err := t.get_underwriting_mortgage(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	decision, err := t.get_underwriting(stub, mortgage.MortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	if decision.Status != UNDERWRITING_OPEN {
+		return nil, errors.New("Underwriting of mortgage " + args[0] + " is already " + decision.Status)
+	}
+
+	outstanding := 0
+	for _, condition := range decision.Conditions {
+		if !condition.Satisfied {
+			outstanding++
+		}
+	}
+
+	decision.ReasonCode = outcome.ReasonCode
+	decision.DecidedBy = username
+
+	switch outcome.Status {
+	case UNDERWRITING_APPROVED:
+		if outstanding > 0 {
+			return nil, errors.New("Mortgage " + args[0] + " has outstanding conditions and can only be conditionally approved")
+		}
+		return nil, t.approve_mortgage(stub, decision, mortgage)
+	case UNDERWRITING_CONDITIONALLY_APPROVED:
+		if outstanding == 0 {
+			return nil, errors.New("Mortgage " + args[0] + " has no outstanding conditions to approve it on")
+		}
+		decision.Status = UNDERWRITING_CONDITIONALLY_APPROVED
+		mortgage.MortgageStage = "Conditionally-Approved:"
+	case UNDERWRITING_DENIED:
+		if outcome.ReasonCode == "" || outcome.AdverseActionReason == "" {
+			return nil, errors.New("ReasonCode and AdverseActionReason are required to deny a mortgage")
+		}
+		decision.Status = UNDERWRITING_DENIED
+		decision.AdverseActionReason = outcome.AdverseActionReason
+		mortgage.MortgageStage = "Denied:"
+	default:
+		return nil, errors.New("Invalid underwriting decision " + outcome.Status)
+	}
+
+	err = t.save_underwriting(stub, decision)
+	if err != nil {
+		return nil, err
+	}
+	return nil, t.save_mortgage(stub, mortgage)
+}
+
+func (t *SimpleChaincode) retrieve_underwriting(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running retrieve_underwriting()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number")
+	}
+	mortgageNumber, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[0])
+	}
+
+	_, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	if role != LENDING_BANK && role != AUDITOR {
+		return nil, errors.New("Permission denied. Only the lending bank or the auditor can retrieve underwriting decisions")
+	}
+
+	decision, err := t.get_underwriting(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(decision)
+}
+
+func (t *SimpleChaincode) retrieve_adverse_action(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Logging
+	fmt.Println("running retrieve_adverse_action()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting mortgage number")
+	}
+	mortgageNumber, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New("Invalid mortgage number " + args[0])
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return nil, err
+	}
+	mortgage, err := t.get_mortgage(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// Customers enrol with their CustomerID as username and can only see their own applications.
+	if (role != CUSTOMER || username != mortgage.CustomerID) && role != LENDING_BANK && role != AUDITOR {
+		return nil, errors.New("Permission denied. Customers can only retrieve their own adverse action notices")
+	}
+
+	decision, err := t.get_underwriting(stub, mortgageNumber)
+	if err != nil {
+		return nil, err
+	}
+	if decision.Status != UNDERWRITING_DENIED {
+		return nil, errors.New("Mortgage " + args[0] + " has not been denied")
+	}
+
+	return json.Marshal(AdverseAction{
+		MortgageNumber:      mortgageNumber,
+		ReasonCode:          decision.ReasonCode,
+		AdverseActionReason: decision.AdverseActionReason,
+		DecidedBy:           decision.DecidedBy,
+	})
+}
+
+//==============================================================================================================================
+//	satisfy_condition - Marks a condition satisfied, a conditionally approved mortgage without outstanding conditions
+//			  is approved.
+//==============================================================================================================================
+func (t *SimpleChaincode) satisfy_condition(stub shim.ChaincodeStubInterface, decision UnderwritingDecision, mortgage Mortgage, index int, username string) error {
+	if decision.Status == UNDERWRITING_DENIED || decision.Status == UNDERWRITING_APPROVED {
+		return errors.New("Underwriting of mortgage " + strconv.Itoa(mortgage.MortgageNumber) + " is already " + decision.Status)
+	}
+
+	// A document condition is only satisfied once a document of its type is attached to the mortgage.
+	condition := decision.Conditions[index]
+	if condition.Type == CONDITION_DOCUMENT {
+		attached, err := t.has_document_type(stub, mortgage, condition.DocumentType)
+		if err != nil {
+			return err
+		}
+		if !attached {
+			return errors.New("No " + condition.DocumentType + " document is attached to mortgage " + strconv.Itoa(mortgage.MortgageNumber))
+		}
+	}
+
+	decision.Conditions[index].Satisfied = true
+	decision.Conditions[index].SatisfiedBy = username
+
+	if decision.Status == UNDERWRITING_CONDITIONALLY_APPROVED {
+		outstanding := false
+		for _, condition := range decision.Conditions {
+			outstanding = outstanding || !condition.Satisfied
+		}
+		if !outstanding {
+			return t.approve_mortgage(stub, decision, mortgage)
+		}
+	}
+	return t.save_underwriting(stub, decision)
+}
+
+//==============================================================================================================================
+//	approve_mortgage - Approves a mortgage applying the rate lock and maximum amount conditions of its decision.
+//==============================================================================================================================
+func (t *SimpleChaincode) approve_mortgage(stub shim.ChaincodeStubInterface, decision UnderwritingDecision, mortgage Mortgage) error {
+	for _, condition := range decision.Conditions {
+		switch condition.Type {
+		case CONDITION_RATE_LOCK:
+			mortgage.RateofInterest = condition.Rate
+			if mortgage.AdjustableRate != nil {
+				mortgage.AdjustableRate.InitialRate = condition.Rate
+			}
+		case CONDITION_MAX_AMOUNT:
+			mortgage.ApprovedMaxAmount = condition.Amount
+		}
+	}
+
+	decision.Status = UNDERWRITING_APPROVED
+	mortgage.MortgageStage = "APPROVED:"
+	err := t.update_risk_profile(stub, &mortgage)
+	if err != nil {
+		return err
+	}
+
+	err = t.save_underwriting(stub, decision)
+	if err != nil {
+		return err
+	}
+	return t.save_mortgage(stub, mortgage)
+}
+
+//==============================================================================================================================
+//	underwriting_decided - Whether underwriting has decided on a mortgage in the given stage, decided mortgages keep the
+//			  terms they were decided on.
+//==============================================================================================================================
+func underwriting_decided(stage string) bool {
+	stage = strings.ToUpper(stage)
+	return strings.HasPrefix(stage, "APPROVED:") || strings.HasPrefix(stage, "CONDITIONALLY-APPROVED:") ||
+		strings.HasPrefix(stage, "DENIED:") || strings.Contains(stage, "DISBURSED:")
+}
+
+//==============================================================================================================================
+//	get_underwriting_mortgage - Underwriting is done by the lending bank, returns its username and the mortgage.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_underwriting_mortgage(stub shim.ChaincodeStubInterface, number string) (string, Mortgage, error) {
+	var mortgage Mortgage
+
+	mortgageNumber, err := strconv.Atoi(number)
+	if err != nil {
+		return "", mortgage, errors.New("Invalid mortgage number " + number)
+	}
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return "", mortgage, err
+	}
+	if role != LENDING_BANK {
+		return "", mortgage, errors.New("Permission denied. Only the lending bank can underwrite mortgages")
+	}
+
+	mortgage, err = t.get_mortgage(stub, mortgageNumber)
+	return username, mortgage, err
+}
+
+//==============================================================================================================================
+//	get_underwriting - Retrieves the UnderwritingDecision of a mortgage from the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_underwriting(stub shim.ChaincodeStubInterface, mortgageNumber int) (UnderwritingDecision, error) {
+	var decision UnderwritingDecision
+
+	bytes, err := stub.GetState(underwriting_key(mortgageNumber))
+	if err != nil {
+		return decision, errors.New("error while fetching underwriting of mortgage " + strconv.Itoa(mortgageNumber))
+	}
+	if bytes == nil {
+		return decision, errors.New("underwriting of mortgage " + strconv.Itoa(mortgageNumber) + " has not been opened")
+	}
+
+	err = json.Unmarshal(bytes, &decision)
+	if err != nil {
+		return decision, errors.New("error while Unmarshalling underwriting of mortgage " + strconv.Itoa(mortgageNumber))
+	}
+	return decision, nil
+}
+
+//==============================================================================================================================
+//	save_underwriting - Writes an UnderwritingDecision record to the blockchain.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_underwriting(stub shim.ChaincodeStubInterface, decision UnderwritingDecision) error {
+	bytes, err := json.Marshal(decision)
+	if err != nil {
+		return errors.New("Error in Marshalling Underwriting record")
+	}
+
+	err = stub.PutState(underwriting_key(decision.MortgageNumber), bytes)
+	if err != nil {
+		return errors.New("Error storing Underwriting record in blockchain")
+	}
+	return nil
+}